@@ -3,9 +3,13 @@ module kafka-repush
 go 1.15
 
 require (
-	github.com/Shopify/sarama v1.27.2
+	github.com/Shopify/sarama v1.38.1
 	github.com/golang/mock v1.4.4
-	github.com/stretchr/testify v1.6.1
+	github.com/klauspost/compress v1.15.14
+	github.com/stretchr/testify v1.8.1
+	github.com/xdg/scram v0.0.0-20180814205039-7eeb5667e42c
+	github.com/xdg/stringprep v1.0.0 // indirect
+	go.etcd.io/bbolt v1.3.6
 	go.uber.org/multierr v1.6.0 // indirect
 	go.uber.org/zap v1.16.0
 	gopkg.in/robfig/cron.v2 v2.0.0-20150107220207-be2e0b0deed5
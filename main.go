@@ -1,15 +1,17 @@
 package main
 
 import (
-	"bufio"
-	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"go.uber.org/zap"
+	"io"
 	"os"
 	"os/signal"
 	"strings"
+	"sync"
 	"syscall"
+	"time"
 
 	"kafka-repush/services"
 
@@ -17,26 +19,94 @@ import (
 )
 
 var (
-	config                         services.Config
-	configFile, logFile, errorFile *os.File
-	sugar                          *zap.SugaredLogger
-	cronService                    *cron.Cron
+	config                services.Config
+	configMu              sync.Mutex
+	errorFile             *os.File
+	checkpointStore       services.CheckpointStore
+	inputName             string
+	configName, errorName string
+	quarantineName        string
+	recursive             bool
+	retryFailed           bool
+	maxAttempts           int
+	checkpointEvery       int
+	checkpointInterval    time.Duration
+	window                services.TimeWindow
+	sortedInput           bool
+	maxLineSize           int
+	pendingLines          int
+	closing               bool
+	lastSave              time.Time
+	sugar                 *zap.SugaredLogger
+	cronService           *cron.Cron
 )
 
 func main() {
-	inputName := flag.String("input", "", "Input file name")
-	configName := flag.String("config", "conf.json", "Service configuration")
-	errorName := flag.String("error", "error.txt", "File name for storing error push")
+	if len(os.Args) > 1 && os.Args[1] == "retry" {
+		runRetryCommand(os.Args[2:])
+		return
+	}
+
+	flag.StringVar(&inputName, "input", "", "Input file name, glob pattern or directory")
+	flag.BoolVar(&recursive, "recursive", false, "Read input directories recursively")
+	flag.StringVar(&configName, "config", "conf.json", "Service configuration")
+	flag.StringVar(&errorName, "error", "error.txt", "File name for storing error push")
 	brokers := flag.String("brokers", "", "Kafka brokers(separate by a space)")
 	schedule := flag.String("schedule", "", "Schedule run with cron format")
+	flag.BoolVar(&retryFailed, "retry", false, "Retry error file entries on every run, not in follow mode")
+	flag.StringVar(&quarantineName, "quarantine", "quarantine.txt", "File name for storing error push failing too many retries")
+	flag.IntVar(&maxAttempts, "max-attempts", 5, "Push attempts before an error push is quarantined, 0 for no limit")
+	deadLetterTopic := flag.String("dlq", "", "Dead letter topic for failed lines, error file is used when it can not be reached")
+	follow := flag.Bool("follow", false, "Keep reading lines appended to input file like tail -F")
+	pollInterval := flag.Duration("poll-interval", time.Second, "Interval for checking input file changes in follow mode")
+	flag.IntVar(&checkpointEvery, "checkpoint-every", 1000, "Save checkpoints after this many lines, 0 to save by interval only")
+	flag.DurationVar(&checkpointInterval, "checkpoint-interval", 5*time.Second, "Save checkpoints at least this often while lines are pushed")
+	filterExpr := flag.String("filter", "", `Push only lines matching expression like 'topic == "orders" && $.total > 100', skipped lines move checkpoints`)
+	from := flag.String("from", "", "Push only lines timestamped at or after this RFC 3339 time, checkpoints are neither used nor moved")
+	to := flag.String("to", "", "Push only lines timestamped before this RFC 3339 time, checkpoints are neither used nor moved")
+	flag.StringVar(&window.Field, "time-field", services.DefaultTimeField, "Field of line holding its timestamp for -from and -to, $.path for a field of JSON message")
+	flag.BoolVar(&sortedInput, "sorted", false, "Input lines are sorted by time, -from is found by binary search and reading stop after -to")
+	flag.IntVar(&maxLineSize, "max-line-size", -1, "Longer lines are recorded as failure without being read whole, only a memory guard as messages are checked against max message bytes. Default is 64MiB or 8 times max message bytes, 0 for no limit")
+	dryRun := flag.Bool("dry-run", false, "Parse lines written since checkpoints and print what would be pushed without sending or moving checkpoints")
 
 	flag.Parse()
 
-	if *inputName == "" {
+	if inputName == "" {
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+	if *brokers == "" && !*dryRun {
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+	if checkpointInterval <= 0 {
+		fmt.Println("-checkpoint-interval must be positive")
 		flag.PrintDefaults()
 		os.Exit(1)
 	}
-	if *brokers == "" {
+	if *follow && *schedule != "" {
+		fmt.Println("-follow and -schedule can not be used together")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+	if retryFailed && *follow {
+		// Follow never ends a run to retry error file, the retry command take turns with it through the error file lock
+		fmt.Println("-retry can not be used with -follow, run the retry command instead")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+	if *dryRun && (*follow || *schedule != "") {
+		fmt.Println("-dry-run can not be used with -follow or -schedule")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+	if err := parseWindow(*from, *to); err != nil {
+		fmt.Println(err)
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+	if !window.IsZero() && (*follow || *schedule != "") {
+		fmt.Println("-from and -to can not be used with -follow or -schedule")
 		flag.PrintDefaults()
 		os.Exit(1)
 	}
@@ -45,48 +115,152 @@ func main() {
 	defer logger.Sync()
 	sugar = logger.Sugar()
 
-	producer, err := services.NewProducer(strings.Split(*brokers, " "))
+	//Get config with given config flag
+	var err error
+	config, err = services.LoadConfig(configName)
 	if err != nil {
-		sugar.Infof("Connect to kafka server failed, err: ", err)
+		sugar.Infof("Get config failed, err: ", err)
+		os.Exit(1)
 	}
 
-	service := services.NewLogHandler(producer)
-
+	//Checkpoints are kept in config file unless another checkpoint store is configured, dry run read them
+	//without taking hold of the store and need brokers only when they are kept in kafka
+	var checkpoints map[string]services.Checkpoint
+	if *dryRun {
+		if *brokers == "" && config.CheckpointsInKafka() {
+			fmt.Println("-brokers must be set for -dry-run when checkpoints are kept in kafka")
+			flag.PrintDefaults()
+			os.Exit(1)
+		}
+		checkpoints, err = services.ReadCheckpoints(configName, &config, strings.Split(*brokers, " "))
+	} else {
+		checkpointStore, err = services.NewCheckpointStore(configName, &config, strings.Split(*brokers, " "))
+		if err != nil {
+			sugar.Infof("Open checkpoint store failed, err: %v", err)
+			os.Exit(1)
+		}
+		checkpoints, err = checkpointStore.Load()
+	}
 	if err != nil {
-		sugar.Infof("Get configuration failed, err: ", err)
+		sugar.Infof("Load checkpoints failed, err: %v", err)
+		os.Exit(1)
 	}
+	for name, cp := range checkpoints {
+		config.SetFileCheckpoint(name, cp)
+	}
+	lastSave = time.Now()
 
-	//Get config with given config flag
-	configFile, err = os.OpenFile(*configName, os.O_RDWR, 6440)
-	if err != nil {
-		sugar.Infof("Get config failed, err: ", err)
+	if err := config.Producer.Validate(); err != nil {
+		sugar.Infof("Invalid producer config, err: %v", err)
+		os.Exit(1)
+	}
+	if maxLineSize < 0 {
+		maxLineSize = config.Producer.LineSizeLimit()
+	} else if maxLineSize > 0 && maxLineSize < config.Producer.MessageBytesLimit() {
+		sugar.Infof("Invalid max line size %d, it must not be below max message bytes %d", maxLineSize, config.Producer.MessageBytesLimit())
+		os.Exit(1)
+	}
+	if err := config.Topics.Validate(); err != nil {
+		sugar.Infof("Invalid topic rules, err: %v", err)
 		os.Exit(1)
 	}
+	//Time window read lines with the decoder of input format
+	if window.Decoder, err = services.NewDecoder(config.Input); err != nil {
+		sugar.Infof("Invalid input format, err: %v", err)
+		os.Exit(1)
+	}
+	var filter *services.Filter
+	if *filterExpr != "" {
+		if filter, err = services.NewFilter(*filterExpr); err != nil {
+			sugar.Infof("Invalid filter, err: %v", err)
+			os.Exit(1)
+		}
+	}
 
-	config, err = service.GetConfig(configFile)
+	//Dry run never connect a producer nor save checkpoints
+	if *dryRun {
+		if err := runDryRun(filter); err != nil {
+			sugar.Infof("Dry run failed, err: %v", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	producer, err := services.NewProducerFromConfig(strings.Split(*brokers, " "), config.Producer)
 	if err != nil {
-		sugar.Infof("Get config failed, err: ", err)
+		sugar.Infof("Connect to kafka server failed, err: %v", err)
+		os.Exit(1)
+	}
+
+	service := services.NewLogHandler(producer)
+	service.SetDeadLetterTopic(*deadLetterTopic)
+	service.SetFilter(filter)
+	service.SetMaxMessageBytes(config.Producer.MessageBytesLimit())
+	if err := service.SetMessageEncoding(config.Encoding); err != nil {
+		sugar.Infof("Invalid message encoding, err: %v", err)
+		os.Exit(1)
+	}
+	if err := service.SetTopicRules(config.Topics); err != nil {
+		sugar.Infof("Invalid topic rules, err: %v", err)
+		os.Exit(1)
+	}
+	if err := service.SetTransforms(config.Transforms); err != nil {
+		sugar.Infof("Invalid transforms, err: %v", err)
+		os.Exit(1)
+	}
+	if err := service.SetInput(config.Input); err != nil {
+		sugar.Infof("Invalid input format, err: %v", err)
 		os.Exit(1)
 	}
 
-	//Get logfile with given input flag
-	logFile, err = os.OpenFile(*inputName, os.O_RDWR, 0755)
+	//Checkpoints committed with transactions take over the ones of config file
+	if service.Transactional() {
+		if *follow {
+			fmt.Println("-follow can not be used with producer transactions")
+			os.Exit(1)
+		}
+		if !window.IsZero() {
+			fmt.Println("-from and -to can not be used with producer transactions")
+			os.Exit(1)
+		}
+		checkpoints, err = services.LoadCheckpoints(strings.Split(*brokers, " "), config.Producer)
+		if err != nil {
+			sugar.Infof("Load committed checkpoints failed, err: %v", err)
+			os.Exit(1)
+		}
+		for name, cp := range checkpoints {
+			config.SetFileCheckpoint(name, cp)
+		}
+	}
+
+	//Check logfiles with given input flag, they are discovered again on every run and waited for in follow mode
+	inputs, err := resolveInputs()
 	if err != nil {
-		sugar.Infof("Get logfile failed, err: ", err)
+		sugar.Infof("Get logfile failed, err: %v", err)
 		os.Exit(1)
 	}
+	for _, name := range inputs {
+		if _, err = os.Stat(name); err != nil && !(*follow && os.IsNotExist(err)) {
+			sugar.Infof("Get logfile failed, err: %v", err)
+			os.Exit(1)
+		}
+	}
 
 	//Get error file with given error flag
-	errorFile, err = os.OpenFile(*errorName, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0755)
+	errorFile, err = os.OpenFile(errorName, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0755)
 	if err != nil {
 		sugar.Infof("Get error file failed, err: ", err)
 		os.Exit(1)
 	}
 
+	if *follow {
+		runFollow(service, *pollInterval)
+		return
+	}
+
 	if *schedule == "" {
 
-		newLastLine := readLogFile(service)
-		config.LastLine = newLastLine
+		runOnce(service)
 		if err := closeService(service); err != nil {
 			sugar.Infof("Close service failed, err: ", err)
 		}
@@ -119,8 +293,7 @@ func runSchedule(service *services.LogHandler, schedule string) error {
 	// Run with schedule setting
 	cronService = cron.New()
 	_, err := cronService.AddFunc(schedule, func() {
-		lastLine := readLogFile(service)
-		config.LastLine = lastLine
+		runOnce(service)
 	})
 	if err != nil {
 		return err
@@ -129,40 +302,500 @@ func runSchedule(service *services.LogHandler, schedule string) error {
 	return nil
 }
 
-func readLogFile(service *services.LogHandler) int64 {
-	scanner := bufio.NewScanner(logFile)
-	var newLastLine int64
-	for scanner.Scan() {
-		newLastLine++
-		if newLastLine > config.LastLine {
-			var logInfo services.LogInfo
-			if err := json.Unmarshal(scanner.Bytes(), &logInfo); err != nil {
-				sugar.Infof("Unmarshal error: ", err)
+func runFollow(service *services.LogHandler, pollInterval time.Duration) {
+	stop := make(chan struct{})
+	stopped := make(chan string)
+	followed := make(map[string]bool)
+	var wg sync.WaitGroup
 
-				if err := service.WriteFailPush(errorFile, scanner.Text()); err != nil {
-					sugar.Infof("Write push failed, err: ", err)
-				}
+	// Start following input files discovered since last call
+	discover := func() {
+		configMu.Lock()
+		inputs, err := resolveInputs()
+		config.PruneCheckpoints()
+		configMu.Unlock()
+		if err != nil {
+			sugar.Infof("Get logfile failed, err: %v", err)
+			return
+		}
+		for _, name := range inputs {
+			if followed[name] {
 				continue
 			}
-			if err := service.SendMessage(logInfo.Topic, logInfo); err != nil {
-				sugar.Infof("Send message to kafka server failed")
-				if err := service.WriteFailPush(errorFile, scanner.Text()); err != nil {
-					sugar.Infof("\"Write fail push failed, err: ", err)
+			followed[name] = true
+			wg.Add(1)
+			go func(name string) {
+				defer wg.Done()
+				configMu.Lock()
+				cp := config.FileCheckpoint(name)
+				configMu.Unlock()
+
+				// Checkpoint only moves past lines which are delivered or recorded as failure
+				tracker := services.NewCheckpointTracker(cp)
+				err := services.Follow(name, cp, pollInterval, maxLineSize, stop, func(line []byte, pos services.Position, cp services.Checkpoint, err error) {
+					ack := tracker.Add(cp)
+					pushLine(service, line, pos, err, func() {
+						ack()
+						configMu.Lock()
+						config.SetFileCheckpoint(name, tracker.Committed())
+						linesDone(1)
+						configMu.Unlock()
+					})
+				})
+				select {
+				case <-stop:
+					return
+				default:
 				}
+				if err != nil {
+					sugar.Infof("Follow logfile %s stopped, err: %v", name, err)
+				} else {
+					sugar.Infof("Logfile %s has been deleted", name)
+				}
+				select {
+				case stopped <- name:
+				case <-stop:
+				}
+			}(name)
+		}
+	}
+	discover()
+
+	ticker := time.NewTicker(checkpointInterval)
+	defer ticker.Stop()
+	exit := make(chan os.Signal, 1)
+	signal.Notify(exit, syscall.SIGTERM, syscall.SIGINT, os.Interrupt, os.Kill)
+	for {
+		select {
+		case <-ticker.C:
+			discover()
+			if err := storeCheckpoints(); err != nil {
+				sugar.Infof("Store checkpoints failed, err: %v", err)
+			}
+		case name := <-stopped:
+			// Follow again on next discovery when it is still an input, a deleted file name is then waited for
+			delete(followed, name)
+		case <-exit:
+			fmt.Println("Exiting...")
+			close(stop)
+			wg.Wait()
+			if err := closeService(service); err != nil {
+				sugar.Infof("Close service with error, err: %v", err)
+			}
+			return
+		}
+	}
+}
+
+//resolveInputs find input files of input flag, config lock must be held
+func resolveInputs() ([]string, error) {
+	inputs, err := services.ResolveInputs(inputName, recursive,
+		configName, errorName, services.LockName(errorName), quarantineName)
+	if err != nil {
+		return nil, err
+	}
+	if len(inputs) == 1 {
+		config.AdoptCheckpoint(inputs[0])
+	}
+	return inputs, nil
+}
+
+//runOnce push lines written since last run, error file is retried first when asked
+func runOnce(service *services.LogHandler) {
+	if retryFailed {
+		retryFailPush(service)
+	}
+	readLogFiles(service)
+}
+
+//retryFailPush retry error file while no line is pushed, error file is reopened as it is replaced
+func retryFailPush(service *services.LogHandler) {
+	configMu.Lock()
+	defer configMu.Unlock()
+	if closing {
+		return
+	}
+
+	if err := errorFile.Close(); err != nil {
+		sugar.Infof("Close error file failed, err: %v", err)
+	}
+	result, err := service.RetryFailPush(errorName, quarantineName, maxAttempts)
+	if err != nil {
+		sugar.Infof("Retry error file failed, err: %v", err)
+	} else {
+		sugar.Infof("Retry error file, sent: %d, failed: %d, quarantined: %d", result.Sent, result.Failed, result.Quarantined)
+	}
+
+	errorFile, err = os.OpenFile(errorName, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0755)
+	if err != nil {
+		sugar.Infof("Get error file failed, err: %v", err)
+		os.Exit(1)
+	}
+}
+
+func readLogFiles(service *services.LogHandler) {
+	configMu.Lock()
+	defer configMu.Unlock()
+	if closing {
+		return
+	}
+
+	inputs, err := resolveInputs()
+	if err != nil {
+		sugar.Infof("Get logfile failed, err: %v", err)
+		return
+	}
+	for _, name := range inputs {
+		readLogFile(service, name)
+	}
+	config.PruneCheckpoints()
+	if err := saveCheckpoints(); err != nil {
+		sugar.Infof("Store checkpoints failed, err: %v", err)
+	}
+}
+
+//readLogFile push lines of input file written since its checkpoint, config lock must be held
+func readLogFile(service *services.LogHandler, name string) {
+	if !window.IsZero() {
+		readLogWindow(service, name)
+		return
+	}
+	if service.Transactional() {
+		readLogFileInTransactions(service, name)
+		return
+	}
+
+	reader, err := openInput(name)
+	if err != nil {
+		sugar.Infof("Open logfile failed, err: %v", err)
+		return
+	}
+	defer reader.Close()
+
+	// Checkpoint only moves past lines which are delivered or recorded as failure
+	tracker := services.NewCheckpointTracker(reader.Checkpoint())
+	for {
+		line, err := reader.ReadLine()
+		if err == io.EOF {
+			break
+		}
+		if err != nil && !lineTooLong(err) {
+			sugar.Infof("Read logfile failed, err: %v", err)
+			break
+		}
+		pushLine(service, line, reader.Position(), err, tracker.Add(reader.Checkpoint()))
+		config.SetFileCheckpoint(name, tracker.Committed())
+		linesDone(1)
+	}
+	if err := service.Flush(); err != nil {
+		sugar.Infof("Flush producer failed, err: %v", err)
+	}
+	config.SetFileCheckpoint(name, tracker.Committed())
+}
+
+//readLogWindow push lines of input file inside time window, checkpoint is left as is
+func readLogWindow(service *services.LogHandler, name string) {
+	reader, err := openInput(name)
+	if err != nil {
+		sugar.Infof("Open logfile failed, err: %v", err)
+		return
+	}
+	defer reader.Close()
+
+	for {
+		line, err := reader.ReadLine()
+		if err == io.EOF {
+			break
+		}
+		if err != nil && !lineTooLong(err) {
+			sugar.Infof("Read logfile failed, err: %v", err)
+			break
+		}
+		// Time of a line too long is unknown, it is recorded as failure
+		if err == nil {
+			inside, past := inWindow(line)
+			if past {
+				break
+			}
+			if !inside {
+				continue
 			}
 		}
+		pushLine(service, line, reader.Position(), err, func() {})
+	}
+	if err := service.Flush(); err != nil {
+		sugar.Infof("Flush producer failed, err: %v", err)
+	}
+}
+
+//parseWindow set time window of -from and -to flags
+func parseWindow(from, to string) error {
+	var err error
+	if from != "" {
+		if window.From, err = time.Parse(time.RFC3339Nano, from); err != nil {
+			return fmt.Errorf("invalid -from: %v", err)
+		}
+	}
+	if to != "" {
+		if window.To, err = time.Parse(time.RFC3339Nano, to); err != nil {
+			return fmt.Errorf("invalid -to: %v", err)
+		}
+	}
+	if !window.From.IsZero() && !window.To.IsZero() && !window.From.Before(window.To) {
+		return errors.New("-from must be before -to")
+	}
+	return nil
+}
+
+//openInput open input file at its checkpoint, or at time window when it is set
+func openInput(name string) (*services.LogReader, error) {
+	var reader *services.LogReader
+	var err error
+	if window.IsZero() {
+		reader, err = services.OpenLogReader(name, config.FileCheckpoint(name))
+	} else {
+		reader, err = openWindowReader(name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	reader.SetMaxLineSize(maxLineSize)
+	return reader, nil
+}
+
+//openWindowReader open input file and its rotated segments for reading time window, sorted input is read
+//from the first line of window
+func openWindowReader(name string) (*services.LogReader, error) {
+	return services.OpenWindowReader(name, window, sortedInput)
+}
+
+//inWindow tell whether line is inside time window and whether sorted input is past it, line without
+//timestamp is outside window
+func inWindow(line []byte) (inside bool, past bool) {
+	t, ok := window.LineTime(line)
+	if !ok {
+		return false, false
+	}
+	return window.Contains(t), sortedInput && window.Past(t)
+}
+
+//readLogFileInTransactions push lines of input file in kafka transactions which commit its checkpoint as well,
+//lines of a failed transaction are read again on next run, config lock must be held
+func readLogFileInTransactions(service *services.LogHandler, name string) {
+	reader, err := openInput(name)
+	if err != nil {
+		sugar.Infof("Open logfile failed, err: %v", err)
+		return
+	}
+	defer reader.Close()
+
+	maxLines := config.Producer.Transaction.TransactionLines()
+	lines := 0
+	abort := func() {
+		if err := service.AbortTransaction(); err != nil {
+			sugar.Infof("Abort transaction failed, err: %v", err)
+		}
+	}
+	commit := func() bool {
+		cp := reader.Checkpoint()
+		if err := service.CommitTransaction(map[string]services.Checkpoint{name: cp}); err != nil {
+			sugar.Infof("Commit transaction failed, err: %v", err)
+			abort()
+			return false
+		}
+		config.SetFileCheckpoint(name, cp)
+		linesDone(lines)
+		lines = 0
+		return true
+	}
+
+	for {
+		line, err := reader.ReadLine()
+		if err == io.EOF {
+			break
+		}
+		if err != nil && !lineTooLong(err) {
+			sugar.Infof("Read logfile failed, err: %v", err)
+			break
+		}
+		if lines == 0 {
+			if err := service.BeginTransaction(); err != nil {
+				sugar.Infof("Begin transaction failed, err: %v", err)
+				return
+			}
+		}
+		lines++
+
+		if err != nil {
+			// Error file is not part of transaction, a crash before commit may record the line twice
+			failLine(service, line, reader.Position(), err)
+		} else if err := service.PushLine(line); err != nil {
+			// Transaction can not go on once a message failed, its lines are read again next time
+			var pushErr *services.PushError
+			if errors.As(err, &pushErr) && pushErr.Class == services.ErrorClassBroker {
+				sugar.Infof("Push line failed, transaction is aborted, err: %v", err)
+				abort()
+				return
+			}
+			failLine(service, line, reader.Position(), err)
+		}
+		if lines >= maxLines && !commit() {
+			return
+		}
+	}
+	if lines > 0 {
+		commit()
+	}
+}
+
+//runDryRun read lines of input files written since their checkpoint and print a summary of messages
+//which would be pushed
+func runDryRun(filter *services.Filter) error {
+	service := services.NewLogHandler(nil)
+	service.SetFilter(filter)
+	service.SetMaxMessageBytes(config.Producer.MessageBytesLimit())
+	if err := service.SetMessageEncoding(config.Encoding); err != nil {
+		return err
+	}
+	if err := service.SetTopicRules(config.Topics); err != nil {
+		return err
+	}
+	if err := service.SetTransforms(config.Transforms); err != nil {
+		return err
+	}
+	if err := service.SetInput(config.Input); err != nil {
+		return err
+	}
+	inputs, err := resolveInputs()
+	if err != nil {
+		return err
+	}
+	report := services.NewDryRunReport()
+	for _, name := range inputs {
+		if err := dryRunLogFile(service, report, name); err != nil {
+			return err
+		}
+	}
+	return report.Write(os.Stdout)
+}
+
+//dryRunLogFile count lines of input file written since its checkpoint or inside time window,
+//checkpoint is left as is
+func dryRunLogFile(service *services.LogHandler, report *services.DryRunReport, name string) error {
+	reader, err := openInput(name)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	for {
+		line, err := reader.ReadLine()
+		if err == io.EOF {
+			return nil
+		}
+		if lineTooLong(err) {
+			pos := reader.Position()
+			sugar.Infof("Invalid line %s:%d, err: %v", pos.Source, pos.Line, err)
+			report.Lines++
+			report.Oversize++
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if !window.IsZero() {
+			inside, past := inWindow(line)
+			if past {
+				return nil
+			}
+			if !inside {
+				report.Lines++
+				report.Skipped++
+				continue
+			}
+		}
+		if err := service.DryRunLine(report, line); err != nil {
+			pos := reader.Position()
+			sugar.Infof("Invalid line %s:%d, err: %v", pos.Source, pos.Line, err)
+		}
+	}
+}
+
+//pushLine send a log line to kafka server, failed line or line which could not be read whole is
+//recorded in error file, done is called once line is delivered or recorded
+func pushLine(service *services.LogHandler, line []byte, pos services.Position, readErr error, done func()) {
+	if readErr != nil {
+		failLine(service, line, pos, readErr)
+		done()
+		return
+	}
+	service.PushLineAsync(line, func(err error) {
+		if err != nil {
+			failLine(service, line, pos, err)
+		}
+		done()
+	})
+}
+
+//failLine record a line which failed to be pushed in error file, a line too long is recorded with its head
+//and length so retry read it again from input file
+func failLine(service *services.LogHandler, line []byte, pos services.Position, err error) {
+	if lineTooLong(err) {
+		err = &services.PushError{Class: services.ErrorClassSize, Err: err}
+	}
+	sugar.Infof("Push line failed, err: %v", err)
+	if err := service.WriteFailure(errorFile, services.NewFailRecord(line, pos, err)); err != nil {
+		sugar.Infof("Write fail push failed, err: %v", err)
+	}
+}
+
+//lineTooLong report whether read error is a line longer than max line size, reading can go on
+func lineTooLong(err error) bool {
+	var tooLong *services.LineTooLongError
+	return errors.As(err, &tooLong)
+}
+
+func storeCheckpoints() error {
+	configMu.Lock()
+	defer configMu.Unlock()
+	return saveCheckpoints()
+}
+
+//saveCheckpoints save checkpoints to checkpoint store, config lock must be held
+func saveCheckpoints() error {
+	pendingLines = 0
+	lastSave = time.Now()
+	return checkpointStore.Save(config.Files)
+}
+
+//linesDone count lines whose checkpoint is set and save checkpoints when it is due, config lock must be held
+func linesDone(lines int) {
+	pendingLines += lines
+	if (checkpointEvery > 0 && pendingLines >= checkpointEvery) || time.Since(lastSave) >= checkpointInterval {
+		if err := saveCheckpoints(); err != nil {
+			sugar.Infof("Store checkpoints failed, err: %v", err)
+		}
 	}
-	return newLastLine
 }
 
 func closeService(service *services.LogHandler) error {
-	if err := service.StoreConfig(configFile, config); err != nil {
+	// A scheduled run still going is waited for and none start after it, so no line is sent while
+	// lines in flight are waited for
+	configMu.Lock()
+	closing = true
+	configMu.Unlock()
+
+	// Wait for lines in flight, their callbacks take the config lock
+	if err := service.Flush(); err != nil {
 		return err
 	}
-	if err := configFile.Close(); err != nil {
+	configMu.Lock()
+	defer configMu.Unlock()
+
+	if err := saveCheckpoints(); err != nil {
 		return err
 	}
-	if err := logFile.Close(); err != nil {
+	if err := checkpointStore.Close(); err != nil {
 		return err
 	}
 	if err := errorFile.Close(); err != nil {
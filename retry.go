@@ -0,0 +1,87 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"kafka-repush/services"
+)
+
+//runRetryCommand retry error file once, entries still failing are kept for the next retry
+func runRetryCommand(args []string) {
+	flags := flag.NewFlagSet("retry", flag.ExitOnError)
+	errorName := flags.String("error", "error.txt", "File name of error push to retry")
+	quarantineName := flags.String("quarantine", "quarantine.txt", "File name for storing error push failing too many retries")
+	maxAttempts := flags.Int("max-attempts", 5, "Push attempts before an error push is quarantined, 0 for no limit")
+	brokers := flags.String("brokers", "", "Kafka brokers(separate by a space)")
+	configName := flags.String("config", "conf.json", "Service configuration, producer settings are used when it exists")
+	flags.Parse(args)
+
+	if *brokers == "" {
+		flags.PrintDefaults()
+		os.Exit(1)
+	}
+
+	logger := zap.NewExample()
+	defer logger.Sync()
+	sugar := logger.Sugar()
+
+	config, err := readRetryConfig(*configName)
+	if err != nil {
+		sugar.Infof("Get config failed, err: %v", err)
+		os.Exit(1)
+	}
+	if err := config.Producer.Validate(); err != nil {
+		sugar.Infof("Invalid producer config, err: %v", err)
+		os.Exit(1)
+	}
+
+	producer, err := services.NewProducerFromConfig(strings.Split(*brokers, " "), config.Producer)
+	if err != nil {
+		sugar.Infof("Connect to kafka server failed, err: %v", err)
+		os.Exit(1)
+	}
+	service := services.NewLogHandler(producer)
+	defer service.Close()
+	service.SetMaxMessageBytes(config.Producer.MessageBytesLimit())
+	if err := service.SetMessageEncoding(config.Encoding); err != nil {
+		sugar.Infof("Invalid message encoding, err: %v", err)
+		os.Exit(1)
+	}
+	if err := service.SetTopicRules(config.Topics); err != nil {
+		sugar.Infof("Invalid topic rules, err: %v", err)
+		os.Exit(1)
+	}
+	if err := service.SetTransforms(config.Transforms); err != nil {
+		sugar.Infof("Invalid transforms, err: %v", err)
+		os.Exit(1)
+	}
+	if err := service.SetInput(config.Input); err != nil {
+		sugar.Infof("Invalid input format, err: %v", err)
+		os.Exit(1)
+	}
+
+	result, err := service.RetryFailPush(*errorName, *quarantineName, *maxAttempts)
+	if err != nil {
+		sugar.Infof("Retry error file failed, err: %v", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Sent: %d, failed: %d, quarantined: %d\n", result.Sent, result.Failed, result.Quarantined)
+}
+
+//readRetryConfig read service configuration, default configuration is used when there is no such file
+func readRetryConfig(name string) (services.Config, error) {
+	file, err := os.Open(name)
+	if os.IsNotExist(err) {
+		return services.Config{}, nil
+	}
+	if err != nil {
+		return services.Config{}, err
+	}
+	defer file.Close()
+	return services.ReadConfig(file)
+}
@@ -0,0 +1,135 @@
+package services
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"io"
+	"os"
+)
+
+// fingerprintSize is the number of bytes from the head of a file used as its fingerprint
+const fingerprintSize = 1024
+
+type (
+	// FileIdentity identify an input file between runs
+	FileIdentity struct {
+		Device          uint64 `json:"device"`
+		Inode           uint64 `json:"inode"`
+		Size            int64  `json:"size"`
+		Fingerprint     string `json:"fingerprint"`
+		FingerprintSize int64  `json:"fingerprintSize"`
+	}
+
+	// Checkpoint is the read position inside an input file
+	Checkpoint struct {
+		Offset  int64        `json:"offset"`
+		Line    int64        `json:"line"`
+		File    FileIdentity `json:"file"`
+		Segment string       `json:"segment,omitempty"`
+	}
+)
+
+//NewFileIdentity get identity of given file
+func NewFileIdentity(file *os.File) (FileIdentity, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return FileIdentity{}, err
+	}
+	device, inode := fileID(file, info)
+	fingerprint, size, err := fileFingerprint(file, fingerprintSize)
+	if err != nil {
+		return FileIdentity{}, err
+	}
+	return FileIdentity{
+		Device:          device,
+		Inode:           inode,
+		Size:            info.Size(),
+		Fingerprint:     fingerprint,
+		FingerprintSize: size,
+	}, nil
+}
+
+//IsZero report whether identity has never been recorded
+func (id FileIdentity) IsZero() bool {
+	return id == FileIdentity{}
+}
+
+//Matches report whether given file is the file described by identity
+func (id FileIdentity) Matches(file *os.File) (bool, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return false, err
+	}
+	device, inode := fileID(file, info)
+	if (id.Device != 0 || id.Inode != 0) && (device != id.Device || inode != id.Inode) {
+		return false, nil
+	}
+	return id.MatchesContent(io.NewSectionReader(file, 0, id.FingerprintSize))
+}
+
+//MatchesContent report whether content read from r starts with the fingerprinted head of identity
+func (id FileIdentity) MatchesContent(r io.Reader) (bool, error) {
+	if id.FingerprintSize == 0 {
+		return true, nil
+	}
+	sum, size, err := fingerprint(r, id.FingerprintSize)
+	if err != nil {
+		return false, err
+	}
+	return size == id.FingerprintSize && sum == id.Fingerprint, nil
+}
+
+//fileFingerprint hash at most n bytes from the head of file, file offset is left unchanged
+func fileFingerprint(file *os.File, n int64) (string, int64, error) {
+	return fingerprint(io.NewSectionReader(file, 0, n), n)
+}
+
+//fingerprint hash at most n bytes read from r
+func fingerprint(r io.Reader, n int64) (string, int64, error) {
+	hash := sha1.New()
+	size, err := io.Copy(hash, io.LimitReader(r, n))
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), size, nil
+}
+
+//FileCheckpoint get checkpoint of given input file
+func (c *Config) FileCheckpoint(name string) Checkpoint {
+	return c.Files[name]
+}
+
+//SetFileCheckpoint record checkpoint of given input file
+func (c *Config) SetFileCheckpoint(name string, cp Checkpoint) {
+	if c.Files == nil {
+		c.Files = make(map[string]Checkpoint)
+	}
+	c.Files[name] = cp
+}
+
+//AdoptCheckpoint move checkpoint recorded when only one input file was supported to given input file
+func (c *Config) AdoptCheckpoint(name string) {
+	if c.Checkpoint == nil {
+		return
+	}
+	if _, ok := c.Files[name]; !ok {
+		c.SetFileCheckpoint(name, *c.Checkpoint)
+	}
+	c.Checkpoint = nil
+}
+
+//PruneCheckpoints remove checkpoints of input files which no longer exist, neither as file nor as rotated segment
+func (c *Config) PruneCheckpoints() {
+	for name, cp := range c.Files {
+		if fileExists(name) || (cp.Segment != "" && fileExists(cp.Segment)) {
+			continue
+		}
+		delete(c.Files, name)
+	}
+}
+
+//fileExists report whether file at path exists
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return !os.IsNotExist(err)
+}
@@ -0,0 +1,116 @@
+package services_test
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"kafka-repush/services"
+	"log"
+	"os"
+	"testing"
+)
+
+func TestFileIdentityMatches(t *testing.T) {
+	logFile, err := ioutil.TempFile("", "example.*.txt")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.Remove(logFile.Name())
+	defer logFile.Close()
+
+	otherFile, err := ioutil.TempFile("", "example.*.txt")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.Remove(otherFile.Name())
+	defer otherFile.Close()
+
+	if _, err := logFile.WriteString("line 1\nline 2\n"); err != nil {
+		log.Fatal(err)
+	}
+	if _, err := otherFile.WriteString("line 1\nline 2\n"); err != nil {
+		log.Fatal(err)
+	}
+	identity, err := services.NewFileIdentity(logFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	testCases := []struct {
+		name     string
+		setUp    func()
+		input    *os.File
+		identity services.FileIdentity
+		output   bool
+	}{
+		{
+			name:     "Same file",
+			input:    logFile,
+			identity: identity,
+			output:   true,
+		},
+		{
+			name:     "Other file with same content",
+			input:    otherFile,
+			identity: identity,
+			output:   false,
+		},
+		{
+			name:  "Same file after append",
+			input: logFile,
+			setUp: func() {
+				if _, err := logFile.WriteString("line 3\n"); err != nil {
+					log.Fatal(err)
+				}
+			},
+			identity: identity,
+			output:   true,
+		},
+		{
+			name:  "Same file after content replaced",
+			input: logFile,
+			setUp: func() {
+				if err := logFile.Truncate(0); err != nil {
+					log.Fatal(err)
+				}
+				if _, err := logFile.WriteAt([]byte("other 1\nother 2\n"), 0); err != nil {
+					log.Fatal(err)
+				}
+			},
+			identity: identity,
+			output:   false,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			if test.setUp != nil {
+				test.setUp()
+			}
+			matches, err := test.identity.Matches(test.input)
+			assert.Nil(t, err)
+			assert.Equal(t, test.output, matches)
+		})
+	}
+}
+
+func TestConfigCheckpoints(t *testing.T) {
+	logFile, err := ioutil.TempFile("", "example.*.txt")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.Remove(logFile.Name())
+	defer logFile.Close()
+	missing := logFile.Name() + ".missing"
+
+	config := services.Config{Checkpoint: &services.Checkpoint{Line: 3}}
+	config.AdoptCheckpoint(logFile.Name())
+	assert.Nil(t, config.Checkpoint)
+	assert.Equal(t, services.Checkpoint{Line: 3}, config.FileCheckpoint(logFile.Name()))
+
+	config.SetFileCheckpoint(missing, services.Checkpoint{Offset: 10})
+	config.SetFileCheckpoint(missing+".rotated", services.Checkpoint{Offset: 10, Segment: logFile.Name()})
+	config.PruneCheckpoints()
+	assert.Equal(t, map[string]services.Checkpoint{
+		logFile.Name():       {Line: 3},
+		missing + ".rotated": {Offset: 10, Segment: logFile.Name()},
+	}, config.Files)
+}
@@ -0,0 +1,101 @@
+package services
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression formats of input files
+const (
+	CompressionNone  = ""
+	CompressionGzip  = "gzip"
+	CompressionZstd  = "zstd"
+	CompressionBzip2 = "bzip2"
+)
+
+// compressedExts are file extensions of compressed inputs
+var compressedExts = map[string]string{
+	".gz":  CompressionGzip,
+	".zst": CompressionZstd,
+	".bz2": CompressionBzip2,
+}
+
+type zstdReader struct {
+	*zstd.Decoder
+}
+
+// bzip2 streams start with "BZh", block size digit and the magic of first block or of stream end, so a text
+// line starting with "BZh5" is not taken for bzip2
+var (
+	bzip2BlockMagic = []byte{0x31, 0x41, 0x59, 0x26, 0x53, 0x59}
+	bzip2EndMagic   = []byte{0x17, 0x72, 0x45, 0x38, 0x50, 0x90}
+)
+
+//DetectCompression get compression format of file from its magic bytes, or from its extension
+//when it is too short to tell. Empty file is not compressed
+func DetectCompression(file *os.File) (string, error) {
+	magic := make([]byte, 10)
+	n, err := file.ReadAt(magic, 0)
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	magic = magic[:n]
+	switch {
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		return CompressionGzip, nil
+	case bytes.HasPrefix(magic, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		return CompressionZstd, nil
+	case isBzip2(magic):
+		return CompressionBzip2, nil
+	case n > 0 && n < 4:
+		return compressedExts[compressedExt(file.Name())], nil
+	}
+	return CompressionNone, nil
+}
+
+//isBzip2 report whether head of file is the header of a bzip2 stream
+func isBzip2(magic []byte) bool {
+	if len(magic) < 10 || !bytes.HasPrefix(magic, []byte("BZh")) || magic[3] < '1' || magic[3] > '9' {
+		return false
+	}
+	return bytes.Equal(magic[4:], bzip2BlockMagic) || bytes.Equal(magic[4:], bzip2EndMagic)
+}
+
+//compressedExt get extension of compressed file name, empty when it has none
+func compressedExt(name string) string {
+	for ext := range compressedExts {
+		if strings.HasSuffix(name, ext) {
+			return ext
+		}
+	}
+	return ""
+}
+
+//newDecompressor read uncompressed content of file in given format
+func newDecompressor(file *os.File, compression string) (io.Reader, error) {
+	switch compression {
+	case CompressionGzip:
+		return gzip.NewReader(file)
+	case CompressionZstd:
+		decoder, err := zstd.NewReader(file, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return zstdReader{decoder}, nil
+	case CompressionBzip2:
+		return bzip2.NewReader(file), nil
+	}
+	return file, nil
+}
+
+//Close release decoder, zstd decoder has no error to report
+func (r zstdReader) Close() error {
+	r.Decoder.Close()
+	return nil
+}
@@ -0,0 +1,224 @@
+package services_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"io/ioutil"
+	"kafka-repush/services"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testdata/lines.bz2 hold compressedLines compressed with bzip2
+var compressedLines = []string{
+	`{"topic":"orders","message":"1"}`,
+	`{"topic":"orders","message":"2"}`,
+	`{"topic":"orders","message":"3"}`,
+}
+
+func compressContent(content []byte, compression string) []byte {
+	var buf bytes.Buffer
+	var writer io.WriteCloser
+	var err error
+	switch compression {
+	case services.CompressionGzip:
+		writer = gzip.NewWriter(&buf)
+	case services.CompressionZstd:
+		if writer, err = zstd.NewWriter(&buf); err != nil {
+			log.Fatal(err)
+		}
+	case services.CompressionBzip2:
+		data, err := ioutil.ReadFile(filepath.Join("testdata", "lines.bz2"))
+		if err != nil {
+			log.Fatal(err)
+		}
+		return data
+	default:
+		return content
+	}
+	if _, err := writer.Write(content); err != nil {
+		log.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		log.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestLogReaderCompressed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "compress")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var content []byte
+	for _, line := range compressedLines {
+		content = append(content, line+"\n"...)
+	}
+	testCases := []struct {
+		name        string
+		fileName    string
+		compression string
+	}{
+		{name: "Plain", fileName: "log.txt", compression: services.CompressionNone},
+		{name: "Gzip", fileName: "log.txt.gz", compression: services.CompressionGzip},
+		{name: "Zstd", fileName: "log.txt.zst", compression: services.CompressionZstd},
+		{name: "Bzip2", fileName: "log.txt.bz2", compression: services.CompressionBzip2},
+		{name: "Detected by magic bytes", fileName: "archive", compression: services.CompressionZstd},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			name := filepath.Join(dir, tc.fileName)
+			if err := ioutil.WriteFile(name, compressContent(content, tc.compression), 0644); err != nil {
+				log.Fatal(err)
+			}
+			file, err := os.Open(name)
+			if err != nil {
+				log.Fatal(err)
+			}
+			compression, err := services.DetectCompression(file)
+			file.Close()
+			assert.Nil(t, err)
+			assert.Equal(t, tc.compression, compression)
+
+			reader, err := services.OpenLogReader(name, services.Checkpoint{})
+			if err != nil {
+				log.Fatal(err)
+			}
+			for _, expLine := range compressedLines[:2] {
+				line, err := reader.ReadLine()
+				assert.Nil(t, err)
+				assert.Equal(t, expLine, string(line))
+			}
+			cp := reader.Checkpoint()
+			reader.Close()
+			assert.Equal(t, int64(2*(len(compressedLines[0])+1)), cp.Offset)
+
+			// Partially read archive resume at its uncompressed offset
+			reader, err = services.OpenLogReader(name, cp)
+			if err != nil {
+				log.Fatal(err)
+			}
+			defer reader.Close()
+			line, err := reader.ReadLine()
+			assert.Nil(t, err)
+			assert.Equal(t, compressedLines[2], string(line))
+			_, err = reader.ReadLine()
+			assert.Equal(t, io.EOF, err)
+		})
+	}
+}
+
+func TestDetectCompression(t *testing.T) {
+	dir, err := ioutil.TempDir("", "compress")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	testCases := []struct {
+		name        string
+		fileName    string
+		content     []byte
+		compression string
+	}{
+		{name: "Bzip2 without extension", fileName: "archive", content: compressContent(nil, services.CompressionBzip2), compression: services.CompressionBzip2},
+		{name: "Text line starting like bzip2", fileName: "raw.log", content: []byte("BZh5 cache warmed up\n"), compression: services.CompressionNone},
+		{name: "Short file with extension", fileName: "log.txt.gz", content: []byte("BZh"), compression: services.CompressionGzip},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			name := filepath.Join(dir, tc.fileName)
+			if err := ioutil.WriteFile(name, tc.content, 0644); err != nil {
+				log.Fatal(err)
+			}
+			file, err := os.Open(name)
+			if err != nil {
+				log.Fatal(err)
+			}
+			defer file.Close()
+			compression, err := services.DetectCompression(file)
+			assert.Nil(t, err)
+			assert.Equal(t, tc.compression, compression)
+		})
+	}
+}
+
+func TestLogReaderCompressedShorter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "compress")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Content is longer than the fingerprinted head, so a shorter archive has the same identity
+	var lines []string
+	var content []byte
+	for i := 0; i < 100; i++ {
+		lines = append(lines, fmt.Sprintf(`{"topic":"orders","message":"%d"}`, i))
+		content = append(content, lines[i]+"\n"...)
+	}
+	name := filepath.Join(dir, "log.txt.gz")
+	if err := ioutil.WriteFile(name, compressContent(content, services.CompressionGzip), 0644); err != nil {
+		log.Fatal(err)
+	}
+	reader, err := services.OpenLogReader(name, services.Checkpoint{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	for range lines[:80] {
+		_, err := reader.ReadLine()
+		assert.Nil(t, err)
+	}
+	cp := reader.Checkpoint()
+	reader.Close()
+
+	half := len(content) / 2
+	truncated := compressContent(content, services.CompressionGzip)
+	testCases := []struct {
+		name       string
+		compressed []byte
+		expLines   int
+	}{
+		{name: "Compressed again with less lines", compressed: compressContent(content[:half+1], services.CompressionGzip), expLines: 50},
+		{name: "Truncated archive", compressed: truncated[:len(truncated)/2]},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := ioutil.WriteFile(name, tc.compressed, 0644); err != nil {
+				log.Fatal(err)
+			}
+			reader, err := services.OpenLogReader(name, cp)
+			if err != nil {
+				log.Fatal(err)
+			}
+			defer reader.Close()
+
+			// Archive is read again from the beginning up to where it end
+			read := 0
+			for {
+				line, err := reader.ReadLine()
+				if err == io.EOF {
+					break
+				}
+				if !assert.Nil(t, err) {
+					return
+				}
+				if read < len(lines) && string(line) == lines[read] {
+					read++
+				}
+			}
+			assert.Greater(t, read, 0)
+			if tc.expLines > 0 {
+				assert.Equal(t, tc.expLines, read)
+			}
+		})
+	}
+}
@@ -0,0 +1,283 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Formats of built-in input decoders
+const (
+	FormatJSON    = "json"
+	FormatRaw     = "raw"
+	FormatCSV     = "csv"
+	FormatTSV     = "tsv"
+	FormatLogfmt  = "logfmt"
+	FormatKcat    = "kcat"
+	FormatConsole = "console"
+)
+
+type (
+	// Decoder read the message to push from an input line
+	Decoder interface {
+		Decode(line []byte) (LogInfo, error)
+	}
+
+	// DecoderFunc is a function used as decoder
+	DecoderFunc func(line []byte) (LogInfo, error)
+
+	// DecoderFactory build a decoder from its configuration
+	DecoderFactory func(options json.RawMessage) (Decoder, error)
+
+	// InputConfig select the decoder of input lines by format, the whole object is given to its
+	// factory as options
+	InputConfig struct {
+		Format  string
+		Options json.RawMessage
+	}
+
+	// FieldMapping name fields of a line holding parts of the message, fields which are not
+	// named are not set. Topic is the topic of lines without topic field
+	FieldMapping struct {
+		Topic          string   `json:"topic"`
+		TopicField     string   `json:"topicField"`
+		KeyField       string   `json:"keyField"`
+		ValueField     string   `json:"valueField"`
+		HeadersField   string   `json:"headersField"`
+		HeaderFields   []string `json:"headerFields"`
+		TimestampField string   `json:"timestampField"`
+		PartitionField string   `json:"partitionField"`
+	}
+
+	// logInfoDecoder read lines written as LogInfo
+	logInfoDecoder struct{}
+
+	// jsonDecoder read JSON lines with fields at dot separated paths
+	jsonDecoder struct {
+		FieldMapping
+	}
+)
+
+var (
+	decodersMu       sync.RWMutex
+	decoderFactories = map[string]DecoderFactory{
+		FormatJSON:    newJSONDecoder,
+		FormatRaw:     newRawDecoder,
+		FormatCSV:     newCSVDecoder,
+		FormatTSV:     newCSVDecoder,
+		FormatLogfmt:  newLogfmtDecoder,
+		FormatKcat:    newKcatDecoder,
+		FormatConsole: newConsoleDecoder,
+	}
+)
+
+//Decode call the function
+func (f DecoderFunc) Decode(line []byte) (LogInfo, error) {
+	return f(line)
+}
+
+//RegisterDecoder make a custom decoder available to input configuration under given format
+func RegisterDecoder(format string, factory DecoderFactory) {
+	decodersMu.Lock()
+	defer decodersMu.Unlock()
+	decoderFactories[format] = factory
+}
+
+//UnmarshalJSON read input format and keep the object as options
+func (c *InputConfig) UnmarshalJSON(data []byte) error {
+	var head struct {
+		Format string `json:"format"`
+	}
+	if err := json.Unmarshal(data, &head); err != nil {
+		return err
+	}
+	c.Format = head.Format
+	c.Options = append(json.RawMessage(nil), data...)
+	return nil
+}
+
+//MarshalJSON write input options which hold its format
+func (c InputConfig) MarshalJSON() ([]byte, error) {
+	if len(c.Options) == 0 {
+		return json.Marshal(map[string]string{"format": c.Format})
+	}
+	return c.Options, nil
+}
+
+//NewDecoder build decoder of input configuration, nil configuration read lines written as LogInfo
+func NewDecoder(conf *InputConfig) (Decoder, error) {
+	if conf == nil {
+		return logInfoDecoder{}, nil
+	}
+	decodersMu.RLock()
+	factory, ok := decoderFactories[conf.Format]
+	decodersMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("input.format: unknown input format %q", conf.Format)
+	}
+	options := conf.Options
+	if len(options) == 0 {
+		options = json.RawMessage("{}")
+	}
+	decoder, err := factory(options)
+	if err != nil {
+		return nil, fmt.Errorf("input: %v", err)
+	}
+	return decoder, nil
+}
+
+//SetInput read lines with decoder of input configuration
+func (h *LogHandler) SetInput(conf *InputConfig) error {
+	decoder, err := NewDecoder(conf)
+	if err != nil {
+		return err
+	}
+	h.decoder = decoder
+	return nil
+}
+
+//SetDecoder read lines with given decoder
+func (h *LogHandler) SetDecoder(decoder Decoder) {
+	h.decoder = decoder
+}
+
+//decode read message of a line with decoder of input configuration
+func (h *LogHandler) decode(line []byte) (LogInfo, error) {
+	return decodeLine(h.decoder, line)
+}
+
+//decodeLine read message of a line with given decoder, lines are read as LogInfo when there is no decoder
+func decodeLine(decoder Decoder, line []byte) (LogInfo, error) {
+	if decoder == nil {
+		return logInfoDecoder{}.Decode(line)
+	}
+	return decoder.Decode(line)
+}
+
+func (logInfoDecoder) Decode(line []byte) (LogInfo, error) {
+	var logInfo LogInfo
+	err := json.Unmarshal(line, &logInfo)
+	return logInfo, err
+}
+
+//newJSONDecoder read LogInfo lines when no field is mapped
+func newJSONDecoder(options json.RawMessage) (Decoder, error) {
+	d := &jsonDecoder{}
+	if err := json.Unmarshal(options, d); err != nil {
+		return nil, err
+	}
+	if d.FieldMapping.isZero() {
+		return logInfoDecoder{}, nil
+	}
+	return d, nil
+}
+
+func (d *jsonDecoder) Decode(line []byte) (LogInfo, error) {
+	decoder := json.NewDecoder(bytes.NewReader(line))
+	decoder.UseNumber()
+	var doc map[string]interface{}
+	if err := decoder.Decode(&doc); err != nil {
+		return LogInfo{}, err
+	}
+	if doc == nil {
+		return LogInfo{}, errors.New("line is not a JSON object")
+	}
+
+	var headers Headers
+	if d.HeadersField != "" {
+		if value, ok := lookupField(doc, d.HeadersField); ok {
+			data, err := json.Marshal(value)
+			if err != nil {
+				return LogInfo{}, err
+			}
+			if err := json.Unmarshal(data, &headers); err != nil {
+				return LogInfo{}, fmt.Errorf("invalid headers field %s: %v", d.HeadersField, err)
+			}
+		}
+	}
+	info, err := d.FieldMapping.logInfo(func(path string) (string, bool) {
+		value, ok := lookupField(doc, path)
+		if !ok || value == nil {
+			return "", false
+		}
+		return fieldString(value), true
+	})
+	if err != nil {
+		return LogInfo{}, err
+	}
+	info.Headers = append(headers, info.Headers...)
+
+	// Value which is not a string is delivered as its JSON text, whole line is the value when no field is named
+	if d.ValueField == "" {
+		info.Message, info.Encoding = string(line), EncodingJSON
+	} else if value, ok := lookupField(doc, d.ValueField); ok && value != nil {
+		if _, isString := value.(string); !isString {
+			info.Encoding = EncodingJSON
+		}
+	}
+	return info, nil
+}
+
+func (m FieldMapping) isZero() bool {
+	return m.Topic == "" && m.TopicField == "" && m.KeyField == "" && m.ValueField == "" && m.HeadersField == "" &&
+		len(m.HeaderFields) == 0 && m.TimestampField == "" && m.PartitionField == ""
+}
+
+//logInfo build message from fields of a line, get return the text of a field and false when
+//line does not have it
+func (m FieldMapping) logInfo(get func(field string) (string, bool)) (LogInfo, error) {
+	info := LogInfo{Topic: m.Topic}
+	if m.TopicField != "" {
+		if topic, ok := get(m.TopicField); ok && topic != "" {
+			info.Topic = topic
+		}
+	}
+	if info.Topic == "" {
+		return LogInfo{}, errors.New("line has no topic")
+	}
+	if m.KeyField != "" {
+		if key, ok := get(m.KeyField); ok {
+			info.MessageKey = &key
+		}
+	}
+	if m.ValueField != "" {
+		info.Message, _ = get(m.ValueField)
+	}
+	for _, name := range m.HeaderFields {
+		if value, ok := get(name); ok {
+			info.Headers = append(info.Headers, Header{Key: name, Value: value})
+		}
+	}
+	if m.TimestampField != "" {
+		if text, ok := get(m.TimestampField); ok {
+			timestamp, err := parseTimestampText(text)
+			if err != nil {
+				return LogInfo{}, fmt.Errorf("invalid timestamp field %s: %v", m.TimestampField, err)
+			}
+			info.Timestamp = timestamp
+		}
+	}
+	if m.PartitionField != "" {
+		if text, ok := get(m.PartitionField); ok {
+			partition, err := strconv.ParseInt(text, 10, 32)
+			if err != nil {
+				return LogInfo{}, fmt.Errorf("invalid partition field %s: %v", m.PartitionField, err)
+			}
+			p := int32(partition)
+			info.Partition = &p
+		}
+	}
+	return info, nil
+}
+
+//parseTimestampText read a timestamp written as RFC 3339 or as milliseconds since epoch
+func parseTimestampText(text string) (time.Time, error) {
+	if millis, err := strconv.ParseInt(text, 10, 64); err == nil {
+		return time.Unix(0, millis*int64(time.Millisecond)).UTC(), nil
+	}
+	return time.Parse(time.RFC3339Nano, text)
+}
@@ -0,0 +1,225 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Markers printed by kafka-console-consumer
+const (
+	consoleCreateTime    = "CreateTime:"
+	consoleLogAppendTime = "LogAppendTime:"
+	consoleNoTimestamp   = "NO_TIMESTAMP"
+	consolePartition     = "Partition:"
+	consoleOffset        = "Offset:"
+	consoleNoHeaders     = "NO_HEADERS"
+	consoleNull          = "null"
+)
+
+type (
+	// kcatDecoder read lines of kcat -J, Topic replace topic of records and IgnorePartition let
+	// partitioner choose partition when partitions of target topic differ
+	kcatDecoder struct {
+		Topic           string `json:"topic"`
+		IgnorePartition bool   `json:"ignorePartition"`
+	}
+
+	// kcatRecord is a record written by kcat -J, headers are a flat list of names and values
+	kcatRecord struct {
+		Topic     string    `json:"topic"`
+		Partition *int32    `json:"partition"`
+		TsType    string    `json:"tstype"`
+		Ts        int64     `json:"ts"`
+		Headers   []*string `json:"headers"`
+		Key       *string   `json:"key"`
+		Payload   *string   `json:"payload"`
+	}
+
+	// consoleDecoder read lines of kafka-console-consumer, print options are the ones of the
+	// print.* properties the dump was written with. Topic must be set as lines do not have it
+	consoleDecoder struct {
+		Topic               string `json:"topic"`
+		IgnorePartition     bool   `json:"ignorePartition"`
+		PrintTimestamp      bool   `json:"printTimestamp"`
+		PrintPartition      bool   `json:"printPartition"`
+		PrintOffset         bool   `json:"printOffset"`
+		PrintHeaders        bool   `json:"printHeaders"`
+		PrintKey            bool   `json:"printKey"`
+		KeySeparator        string `json:"keySeparator"`
+		HeadersSeparator    string `json:"headersSeparator"`
+		HeadersKeySeparator string `json:"headersKeySeparator"`
+		NullLiteral         string `json:"nullLiteral"`
+	}
+)
+
+func newKcatDecoder(options json.RawMessage) (Decoder, error) {
+	d := &kcatDecoder{}
+	if err := json.Unmarshal(options, d); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+//Decode read a kcat record, null payload is sent as a tombstone
+func (d *kcatDecoder) Decode(line []byte) (LogInfo, error) {
+	var record kcatRecord
+	if err := json.Unmarshal(line, &record); err != nil {
+		return LogInfo{}, err
+	}
+	info := LogInfo{Topic: record.Topic, MessageKey: record.Key}
+	if d.Topic != "" {
+		info.Topic = d.Topic
+	}
+	if info.Topic == "" {
+		return LogInfo{}, errors.New("line has no topic")
+	}
+	if record.Payload != nil {
+		info.Message = *record.Payload
+	} else {
+		info.Null = true
+	}
+	if !d.IgnorePartition {
+		info.Partition = record.Partition
+	}
+	if record.TsType != "" && record.TsType != "unknown" && record.Ts > 0 {
+		info.Timestamp = time.Unix(0, record.Ts*int64(time.Millisecond)).UTC()
+	}
+
+	if len(record.Headers)%2 != 0 {
+		return LogInfo{}, errors.New("kcat headers must be pairs of name and value")
+	}
+	for i := 0; i < len(record.Headers); i += 2 {
+		if record.Headers[i] == nil {
+			return LogInfo{}, fmt.Errorf("kcat header %d has no name", i/2)
+		}
+		header := Header{Key: *record.Headers[i]}
+		if record.Headers[i+1] != nil {
+			header.Value = *record.Headers[i+1]
+		}
+		info.Headers = append(info.Headers, header)
+	}
+	return info, nil
+}
+
+func newConsoleDecoder(options json.RawMessage) (Decoder, error) {
+	d := &consoleDecoder{KeySeparator: "\t", HeadersSeparator: ",", HeadersKeySeparator: ":", NullLiteral: consoleNull}
+	if err := json.Unmarshal(options, d); err != nil {
+		return nil, err
+	}
+	if d.Topic == "" {
+		return nil, errors.New("console input topic must be set")
+	}
+	if d.KeySeparator == "" || d.HeadersSeparator == "" || d.HeadersKeySeparator == "" {
+		return nil, errors.New("console input separators must not be empty")
+	}
+	return d, nil
+}
+
+//Decode read fields printed before the value in the order of kafka-console-consumer, value is the
+//rest of the line so it may hold the separator. Null key is no key and null value is a tombstone
+func (d *consoleDecoder) Decode(line []byte) (LogInfo, error) {
+	fields := 1
+	for _, printed := range []bool{d.PrintTimestamp, d.PrintPartition, d.PrintOffset, d.PrintHeaders, d.PrintKey} {
+		if printed {
+			fields++
+		}
+	}
+	parts := strings.SplitN(string(line), d.KeySeparator, fields)
+	if len(parts) != fields {
+		return LogInfo{}, fmt.Errorf("line has %d fields, expected %d", len(parts), fields)
+	}
+	next := func() string {
+		part := parts[0]
+		parts = parts[1:]
+		return part
+	}
+
+	info := LogInfo{Topic: d.Topic}
+	if d.PrintTimestamp {
+		timestamp, err := parseConsoleTimestamp(next())
+		if err != nil {
+			return LogInfo{}, err
+		}
+		info.Timestamp = timestamp
+	}
+	if d.PrintPartition {
+		text := next()
+		if !strings.HasPrefix(text, consolePartition) {
+			return LogInfo{}, fmt.Errorf("invalid partition %q", text)
+		}
+		partition, err := strconv.ParseInt(strings.TrimPrefix(text, consolePartition), 10, 32)
+		if err != nil {
+			return LogInfo{}, fmt.Errorf("invalid partition %q", text)
+		}
+		if !d.IgnorePartition {
+			p := int32(partition)
+			info.Partition = &p
+		}
+	}
+	if d.PrintOffset {
+		if text := next(); !strings.HasPrefix(text, consoleOffset) {
+			return LogInfo{}, fmt.Errorf("invalid offset %q", text)
+		}
+	}
+	if d.PrintHeaders {
+		headers, err := d.parseHeaders(next())
+		if err != nil {
+			return LogInfo{}, err
+		}
+		info.Headers = headers
+	}
+	if d.PrintKey {
+		if key := next(); key != d.NullLiteral {
+			info.MessageKey = &key
+		}
+	}
+	if value := next(); value != d.NullLiteral {
+		info.Message = value
+	} else {
+		info.Null = true
+	}
+	return info, nil
+}
+
+//parseHeaders read headers printed as name:value pairs, null value is empty
+func (d *consoleDecoder) parseHeaders(text string) (Headers, error) {
+	if text == consoleNoHeaders {
+		return nil, nil
+	}
+	var headers Headers
+	for _, pair := range strings.Split(text, d.HeadersSeparator) {
+		i := strings.Index(pair, d.HeadersKeySeparator)
+		if i < 0 {
+			return nil, fmt.Errorf("invalid header %q", pair)
+		}
+		header := Header{Key: pair[:i], Value: pair[i+len(d.HeadersKeySeparator):]}
+		if header.Value == d.NullLiteral {
+			header.Value = ""
+		}
+		headers = append(headers, header)
+	}
+	return headers, nil
+}
+
+//parseConsoleTimestamp read timestamp printed as CreateTime:<ms>, LogAppendTime:<ms> or NO_TIMESTAMP
+func parseConsoleTimestamp(text string) (time.Time, error) {
+	if text == consoleNoTimestamp {
+		return time.Time{}, nil
+	}
+	millis := text
+	for _, prefix := range []string{consoleCreateTime, consoleLogAppendTime} {
+		millis = strings.TrimPrefix(millis, prefix)
+	}
+	ms, err := strconv.ParseInt(millis, 10, 64)
+	if millis == text || err != nil {
+		return time.Time{}, fmt.Errorf("invalid timestamp %q", text)
+	}
+	if ms < 0 {
+		return time.Time{}, nil
+	}
+	return time.Unix(0, ms*int64(time.Millisecond)).UTC(), nil
+}
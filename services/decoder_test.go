@@ -0,0 +1,336 @@
+package services_test
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/Shopify/sarama"
+	"github.com/Shopify/sarama/mocks"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"kafka-repush/services"
+	"log"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDecoders(t *testing.T) {
+	key, partition := "k1", int32(2)
+	timestamp := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+	testCases := []struct {
+		name    string
+		input   string
+		line    string
+		expInfo services.LogInfo
+		expErr  string
+	}{
+		{
+			name:    "No input read LogInfo",
+			line:    `{"topic":"orders","message":"m1","key":"k1"}`,
+			expInfo: services.LogInfo{Topic: "orders", Message: "m1", MessageKey: &key},
+		},
+		{
+			name:    "Raw line",
+			input:   `{"format":"raw","topic":"logs"}`,
+			line:    `plain "text" line`,
+			expInfo: services.LogInfo{Topic: "logs", Message: `plain "text" line`},
+		},
+		{
+			name:  "JSON field paths",
+			input: `{"format":"json","topicField":"meta.topic","keyField":"id","valueField":"body","headersField":"meta.headers","headerFields":["region"],"timestampField":"ts","partitionField":"meta.p"}`,
+			line:  `{"meta":{"topic":"orders","p":2,"headers":{"trace":"t1"}},"id":"k1","body":{"total":12.50},"region":"eu","ts":1672628645000}`,
+			expInfo: services.LogInfo{Topic: "orders", Message: `{"total":12.50}`, Encoding: services.EncodingJSON, MessageKey: &key,
+				Headers: services.Headers{{Key: "trace", Value: "t1"}, {Key: "region", Value: "eu"}}, Partition: &partition, Timestamp: timestamp},
+		},
+		{
+			name:    "JSON whole line with fixed topic",
+			input:   `{"format":"json","topic":"events","keyField":"id"}`,
+			line:    `{"id":"k1","n":1}`,
+			expInfo: services.LogInfo{Topic: "events", Message: `{"id":"k1","n":1}`, Encoding: services.EncodingJSON, MessageKey: &key},
+		},
+		{
+			name:   "JSON line without topic",
+			input:  `{"format":"json","topicField":"topic"}`,
+			line:   `{"id":"k1"}`,
+			expErr: "line has no topic",
+		},
+		{
+			name:  "CSV columns",
+			input: `{"format":"csv","columns":["topic","key","","value","header:trace","timestamp","partition"]}`,
+			line:  `orders,k1,skipped,"a,""b""",t1,2023-01-02T03:04:05Z,2`,
+			expInfo: services.LogInfo{Topic: "orders", Message: `a,"b"`, MessageKey: &key,
+				Headers: services.Headers{{Key: "trace", Value: "t1"}}, Partition: &partition, Timestamp: timestamp},
+		},
+		{
+			name:    "CSV custom delimiter and fixed topic",
+			input:   `{"format":"csv","topic":"orders","delimiter":";","columns":["key","value"]}`,
+			line:    `k1;v1`,
+			expInfo: services.LogInfo{Topic: "orders", Message: "v1", MessageKey: &key},
+		},
+		{
+			name:    "TSV columns",
+			input:   `{"format":"tsv","topic":"orders","columns":["key","value"]}`,
+			line:    "k1\tv,1",
+			expInfo: services.LogInfo{Topic: "orders", Message: "v,1", MessageKey: &key},
+		},
+		{
+			name:   "CSV invalid partition",
+			input:  `{"format":"csv","topic":"orders","columns":["value","partition"]}`,
+			line:   `v1,first`,
+			expErr: `invalid partition field partition: strconv.ParseInt: parsing "first": invalid syntax`,
+		},
+		{
+			name:  "Logfmt fields",
+			input: `{"format":"logfmt","topicField":"topic","keyField":"id","valueField":"msg","headerFields":["level"]}`,
+			line:  `topic=orders id=k1 level=info msg="order \"7\" paid" debug`,
+			expInfo: services.LogInfo{Topic: "orders", Message: `order "7" paid`, MessageKey: &key,
+				Headers: services.Headers{{Key: "level", Value: "info"}}},
+		},
+		{
+			name:    "Logfmt whole line",
+			input:   `{"format":"logfmt","topic":"logs","keyField":"id"}`,
+			line:    `id=k1 level=warn`,
+			expInfo: services.LogInfo{Topic: "logs", Message: `id=k1 level=warn`, MessageKey: &key},
+		},
+		{
+			name:   "Logfmt unterminated value",
+			input:  `{"format":"logfmt","topic":"logs"}`,
+			line:   `msg="open`,
+			expErr: "unterminated logfmt value of msg",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var input *services.InputConfig
+			if tc.input != "" {
+				input = &services.InputConfig{}
+				if err := json.Unmarshal([]byte(tc.input), input); err != nil {
+					log.Fatal(err)
+				}
+			}
+			ctrl := gomock.NewController(t)
+			mockKafka := NewMockProducer(ctrl)
+			if tc.expErr == "" {
+				mockKafka.EXPECT().Send(tc.expInfo.Topic, gomock.Any()).DoAndReturn(func(topic string, msg services.ProducerMessage) error {
+					assert.Equal(t, tc.expInfo, msg.(services.LogInfo))
+					return nil
+				})
+			}
+			service := services.NewLogHandler(mockKafka)
+			assert.Nil(t, service.SetInput(input))
+
+			err := service.PushLine([]byte(tc.line))
+			if tc.expErr == "" {
+				assert.Nil(t, err)
+				return
+			}
+			var pushErr *services.PushError
+			if assert.True(t, errors.As(err, &pushErr)) {
+				assert.Equal(t, services.ErrorClassParse, pushErr.Class)
+				assert.Equal(t, tc.expErr, pushErr.Error())
+			}
+		})
+	}
+}
+
+func TestDumpDecoders(t *testing.T) {
+	key, partition := "k1", int32(3)
+	timestamp := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+	testCases := []struct {
+		name    string
+		input   string
+		line    string
+		expInfo services.LogInfo
+		expErr  string
+	}{
+		{
+			name:  "Kcat record",
+			input: `{"format":"kcat"}`,
+			line:  `{"topic":"orders","partition":3,"offset":42,"tstype":"create","ts":1672628645000,"broker":1,"headers":["trace","t1","empty",null],"key":"k1","payload":"{\"id\":7}"}`,
+			expInfo: services.LogInfo{Topic: "orders", Message: `{"id":7}`, MessageKey: &key,
+				Headers: services.Headers{{Key: "trace", Value: "t1"}, {Key: "empty"}}, Partition: &partition, Timestamp: timestamp},
+		},
+		{
+			name:    "Kcat record to other topic with null key and payload",
+			input:   `{"format":"kcat","topic":"orders-copy","ignorePartition":true}`,
+			line:    `{"topic":"orders","partition":3,"offset":43,"tstype":"unknown","ts":-1,"broker":1,"key":null,"payload":null}`,
+			expInfo: services.LogInfo{Topic: "orders-copy", Null: true},
+		},
+		{
+			name:   "Kcat headers not paired",
+			input:  `{"format":"kcat"}`,
+			line:   `{"topic":"orders","headers":["trace"],"payload":"v"}`,
+			expErr: "kcat headers must be pairs of name and value",
+		},
+		{
+			name:    "Console key and value",
+			input:   `{"format":"console","topic":"orders","printKey":true}`,
+			line:    "k1\tvalue\twith tab",
+			expInfo: services.LogInfo{Topic: "orders", Message: "value\twith tab", MessageKey: &key},
+		},
+		{
+			name:  "Console all fields",
+			input: `{"format":"console","topic":"orders","printTimestamp":true,"printPartition":true,"printOffset":true,"printHeaders":true,"printKey":true}`,
+			line:  "CreateTime:1672628645000\tPartition:3\tOffset:42\ttrace:t1,empty:null\tk1\t{\"id\":7}",
+			expInfo: services.LogInfo{Topic: "orders", Message: `{"id":7}`, MessageKey: &key,
+				Headers: services.Headers{{Key: "trace", Value: "t1"}, {Key: "empty"}}, Partition: &partition, Timestamp: timestamp},
+		},
+		{
+			name:    "Console null key and no headers",
+			input:   `{"format":"console","topic":"orders","printTimestamp":true,"printHeaders":true,"printKey":true,"keySeparator":"|"}`,
+			line:    "NO_TIMESTAMP|NO_HEADERS|null|v1",
+			expInfo: services.LogInfo{Topic: "orders", Message: "v1"},
+		},
+		{
+			name:   "Console missing fields",
+			input:  `{"format":"console","topic":"orders","printPartition":true,"printKey":true}`,
+			line:   "Partition:3\tv1",
+			expErr: "line has 2 fields, expected 3",
+		},
+		{
+			name:   "Console invalid timestamp",
+			input:  `{"format":"console","topic":"orders","printTimestamp":true}`,
+			line:   "Time:1\tv1",
+			expErr: `invalid timestamp "Time:1"`,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			input := &services.InputConfig{}
+			if err := json.Unmarshal([]byte(tc.input), input); err != nil {
+				log.Fatal(err)
+			}
+			decoder, err := services.NewDecoder(input)
+			if err != nil {
+				log.Fatal(err)
+			}
+			info, err := decoder.Decode([]byte(tc.line))
+			if tc.expErr != "" {
+				if assert.NotNil(t, err) {
+					assert.Equal(t, tc.expErr, err.Error())
+				}
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, tc.expInfo, info)
+		})
+	}
+}
+
+func TestDumpDecodersTombstone(t *testing.T) {
+	testCases := []struct {
+		name  string
+		input string
+		line  string
+	}{
+		{
+			name:  "Kcat null payload",
+			input: `{"format":"kcat"}`,
+			line:  `{"topic":"users","partition":0,"offset":7,"tstype":"create","ts":1672628645000,"key":"u1","payload":null}`,
+		},
+		{
+			name:  "Console null value",
+			input: `{"format":"console","topic":"users","printKey":true}`,
+			line:  "u1\tnull",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			input := &services.InputConfig{}
+			if err := json.Unmarshal([]byte(tc.input), input); err != nil {
+				log.Fatal(err)
+			}
+			config := sarama.NewConfig()
+			config.Producer.Return.Successes = true
+			mockKafka := mocks.NewSyncProducer(t, config)
+			mockKafka.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(func(msg *sarama.ProducerMessage) error {
+				key, _ := msg.Key.Encode()
+				assert.Equal(t, "u1", string(key))
+				assert.Nil(t, msg.Value)
+				return nil
+			})
+			service := services.NewLogHandler(&services.KafkaProducer{Prod: mockKafka})
+			assert.Nil(t, service.SetInput(input))
+			assert.Nil(t, service.PushLine([]byte(tc.line)))
+			assert.Nil(t, mockKafka.Close())
+		})
+	}
+}
+
+func TestCustomDecoder(t *testing.T) {
+	services.RegisterDecoder("upper", func(options json.RawMessage) (services.Decoder, error) {
+		return services.DecoderFunc(func(line []byte) (services.LogInfo, error) {
+			return services.LogInfo{Topic: "orders", Message: strings.ToUpper(string(line))}, nil
+		}), nil
+	})
+	input := &services.InputConfig{}
+	if err := json.Unmarshal([]byte(`{"format":"upper"}`), input); err != nil {
+		log.Fatal(err)
+	}
+
+	ctrl := gomock.NewController(t)
+	mockKafka := NewMockProducer(ctrl)
+	mockKafka.EXPECT().Send("orders", gomock.Any()).DoAndReturn(func(topic string, msg services.ProducerMessage) error {
+		assert.Equal(t, "HELLO", msg.(services.LogInfo).Message)
+		return nil
+	})
+	service := services.NewLogHandler(mockKafka)
+	assert.Nil(t, service.SetInput(input))
+	assert.Nil(t, service.PushLine([]byte("hello")))
+}
+
+func TestNewDecoderInvalid(t *testing.T) {
+	testCases := []struct {
+		name   string
+		input  string
+		expErr string
+	}{
+		{
+			name:   "Unknown format",
+			input:  `{"format":"xml"}`,
+			expErr: `input.format: unknown input format "xml"`,
+		},
+		{
+			name:   "Raw without topic",
+			input:  `{"format":"raw"}`,
+			expErr: "input: raw input topic must be set",
+		},
+		{
+			name:   "CSV unknown column",
+			input:  `{"format":"csv","topic":"orders","columns":["value","offset"]}`,
+			expErr: `input: columns[1]: unknown column "offset"`,
+		},
+		{
+			name:   "CSV without value column",
+			input:  `{"format":"csv","topic":"orders","columns":["key"]}`,
+			expErr: "input: csv input columns must have a value column",
+		},
+		{
+			name:   "CSV invalid delimiter",
+			input:  `{"format":"csv","topic":"orders","delimiter":";;","columns":["value"]}`,
+			expErr: `input: invalid csv delimiter ";;"`,
+		},
+		{
+			name:   "Console without topic",
+			input:  `{"format":"console","printKey":true}`,
+			expErr: "input: console input topic must be set",
+		},
+		{
+			name:   "Logfmt without topic",
+			input:  `{"format":"logfmt","keyField":"id"}`,
+			expErr: "input: logfmt input topic or topicField must be set",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			input := &services.InputConfig{}
+			if err := json.Unmarshal([]byte(tc.input), input); err != nil {
+				log.Fatal(err)
+			}
+			_, err := services.NewDecoder(input)
+			if assert.NotNil(t, err) {
+				assert.Equal(t, tc.expErr, err.Error())
+			}
+		})
+	}
+}
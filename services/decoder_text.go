@@ -0,0 +1,217 @@
+package services
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// headerColumn is the prefix of a CSV column role naming a header
+const headerColumn = "header:"
+
+type (
+	// rawDecoder send lines as they are to a fixed topic
+	rawDecoder struct {
+		Topic string `json:"topic"`
+	}
+
+	// csvDecoder read delimited lines, columns name the role of every column which is topic, key,
+	// value, timestamp, partition, header:<name> or empty to ignore the column
+	csvDecoder struct {
+		Topic     string   `json:"topic"`
+		Delimiter string   `json:"delimiter"`
+		Columns   []string `json:"columns"`
+		comma     rune
+	}
+
+	// logfmtDecoder read key=value lines, whole line is the value when no value field is named
+	logfmtDecoder struct {
+		FieldMapping
+	}
+)
+
+func newRawDecoder(options json.RawMessage) (Decoder, error) {
+	d := &rawDecoder{}
+	if err := json.Unmarshal(options, d); err != nil {
+		return nil, err
+	}
+	if d.Topic == "" {
+		return nil, errors.New("raw input topic must be set")
+	}
+	return d, nil
+}
+
+func (d *rawDecoder) Decode(line []byte) (LogInfo, error) {
+	return LogInfo{Topic: d.Topic, Message: string(line)}, nil
+}
+
+func newCSVDecoder(options json.RawMessage) (Decoder, error) {
+	d := &csvDecoder{}
+	if err := json.Unmarshal(options, d); err != nil {
+		return nil, err
+	}
+	var format struct {
+		Format string `json:"format"`
+	}
+	if err := json.Unmarshal(options, &format); err != nil {
+		return nil, err
+	}
+
+	d.comma = ','
+	if format.Format == FormatTSV {
+		d.comma = '\t'
+	}
+	if d.Delimiter != "" {
+		r, size := utf8.DecodeRuneInString(d.Delimiter)
+		if size != len(d.Delimiter) || r == '"' || r == '\r' || r == '\n' {
+			return nil, fmt.Errorf("invalid csv delimiter %q", d.Delimiter)
+		}
+		d.comma = r
+	}
+
+	hasTopic, hasValue := d.Topic != "", false
+	for i, role := range d.Columns {
+		switch {
+		case role == "topic":
+			hasTopic = true
+		case role == "value":
+			hasValue = true
+		case role == "", role == "key", role == "timestamp", role == "partition":
+		case strings.HasPrefix(role, headerColumn) && len(role) > len(headerColumn):
+		default:
+			return nil, fmt.Errorf("columns[%d]: unknown column %q", i, role)
+		}
+	}
+	if !hasValue {
+		return nil, errors.New("csv input columns must have a value column")
+	}
+	if !hasTopic {
+		return nil, errors.New("csv input topic or a topic column must be set")
+	}
+	return d, nil
+}
+
+func (d *csvDecoder) Decode(line []byte) (LogInfo, error) {
+	reader := csv.NewReader(bytes.NewReader(line))
+	reader.Comma = d.comma
+	reader.LazyQuotes = true
+	reader.FieldsPerRecord = -1
+	record, err := reader.Read()
+	if err != nil {
+		return LogInfo{}, err
+	}
+
+	fields := make(map[string]string)
+	var headers Headers
+	for i, role := range d.Columns {
+		if i >= len(record) || role == "" {
+			continue
+		}
+		if strings.HasPrefix(role, headerColumn) {
+			headers = append(headers, Header{Key: strings.TrimPrefix(role, headerColumn), Value: record[i]})
+			continue
+		}
+		fields[role] = record[i]
+	}
+	mapping := FieldMapping{Topic: d.Topic, TopicField: "topic", KeyField: "key", ValueField: "value",
+		TimestampField: "timestamp", PartitionField: "partition"}
+	info, err := mapping.logInfo(func(field string) (string, bool) {
+		value, ok := fields[field]
+		return value, ok
+	})
+	if err != nil {
+		return LogInfo{}, err
+	}
+	info.Headers = headers
+	return info, nil
+}
+
+func newLogfmtDecoder(options json.RawMessage) (Decoder, error) {
+	d := &logfmtDecoder{}
+	if err := json.Unmarshal(options, d); err != nil {
+		return nil, err
+	}
+	if d.Topic == "" && d.TopicField == "" {
+		return nil, errors.New("logfmt input topic or topicField must be set")
+	}
+	if d.HeadersField != "" {
+		return nil, errors.New("logfmt input has no headers field, use headerFields")
+	}
+	return d, nil
+}
+
+func (d *logfmtDecoder) Decode(line []byte) (LogInfo, error) {
+	fields, err := parseLogfmt(string(line))
+	if err != nil {
+		return LogInfo{}, err
+	}
+	info, err := d.FieldMapping.logInfo(func(field string) (string, bool) {
+		value, ok := fields[field]
+		return value, ok
+	})
+	if err != nil {
+		return LogInfo{}, err
+	}
+	if d.ValueField == "" {
+		info.Message = string(line)
+	}
+	return info, nil
+}
+
+//parseLogfmt read key=value pairs separated by spaces, values may be double quoted with escapes
+//and a key without value is empty
+func parseLogfmt(line string) (map[string]string, error) {
+	fields := make(map[string]string)
+	i := 0
+	for {
+		for i < len(line) && (line[i] == ' ' || line[i] == '\t') {
+			i++
+		}
+		if i >= len(line) {
+			return fields, nil
+		}
+		start := i
+		for i < len(line) && line[i] != '=' && line[i] != ' ' && line[i] != '\t' {
+			i++
+		}
+		key := line[start:i]
+		if key == "" {
+			return nil, fmt.Errorf("logfmt key expected at %d", start)
+		}
+		if i >= len(line) || line[i] != '=' {
+			fields[key] = ""
+			continue
+		}
+		i++
+
+		if i < len(line) && line[i] == '"' {
+			end := i + 1
+			for end < len(line) && line[end] != '"' {
+				if line[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(line) {
+				return nil, fmt.Errorf("unterminated logfmt value of %s", key)
+			}
+			value, err := strconv.Unquote(line[i : end+1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid logfmt value of %s: %v", key, err)
+			}
+			fields[key] = value
+			i = end + 1
+			continue
+		}
+		start = i
+		for i < len(line) && line[i] != ' ' && line[i] != '\t' {
+			i++
+		}
+		fields[key] = line[start:i]
+	}
+}
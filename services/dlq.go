@@ -0,0 +1,76 @@
+package services
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// Headers of messages sent to dead letter topic
+const (
+	HeaderErrorClass = "repush.error.class"
+	HeaderError      = "repush.error"
+	HeaderTopic      = "repush.topic"
+	HeaderSource     = "repush.source"
+	HeaderOffset     = "repush.offset"
+	HeaderLine       = "repush.line"
+	HeaderTime       = "repush.time"
+	HeaderAttempts   = "repush.attempts"
+	HeaderLength     = "repush.length"
+)
+
+// DeadLetter is a failed line sent to dead letter topic, failure details are carried in headers
+type DeadLetter struct {
+	Record FailRecord
+}
+
+// Key ..
+func (d DeadLetter) Key() string {
+	return d.Record.Line
+}
+
+//Encode build kafka message holding the line as is
+func (d DeadLetter) Encode(topic string) (*sarama.ProducerMessage, error) {
+	r := d.Record
+	headers := []sarama.RecordHeader{
+		{Key: []byte(HeaderErrorClass), Value: []byte(r.Class)},
+		{Key: []byte(HeaderError), Value: []byte(r.Error)},
+		{Key: []byte(HeaderTopic), Value: []byte(r.Topic)},
+		{Key: []byte(HeaderSource), Value: []byte(r.Source)},
+		{Key: []byte(HeaderOffset), Value: []byte(strconv.FormatInt(r.Offset, 10))},
+		{Key: []byte(HeaderLine), Value: []byte(strconv.FormatInt(r.Position.Line, 10))},
+		{Key: []byte(HeaderTime), Value: []byte(r.Time.Format(time.RFC3339Nano))},
+		{Key: []byte(HeaderAttempts), Value: []byte(strconv.Itoa(r.Attempts))},
+	}
+	if r.Length > 0 {
+		// Line too long is carried with its head only
+		headers = append(headers, sarama.RecordHeader{Key: []byte(HeaderLength), Value: []byte(strconv.FormatInt(r.Length, 10))})
+	}
+	return &sarama.ProducerMessage{
+		Topic:     topic,
+		Partition: -1,
+		Value:     sarama.ByteEncoder(r.Line),
+		Headers:   headers,
+	}, nil
+}
+
+//SetDeadLetterTopic route failures to given topic, empty topic keep failures in error file only
+func (h *LogHandler) SetDeadLetterTopic(topic string) {
+	h.deadLetterTopic = topic
+}
+
+//WriteFailure send failure record to dead letter topic, record is written to error file
+//when there is no dead letter topic or it can not be reached
+func (h *LogHandler) WriteFailure(file *os.File, record FailRecord) error {
+	if h.deadLetterTopic != "" {
+		err := h.prod.Send(h.deadLetterTopic, DeadLetter{Record: record})
+		if err == nil {
+			return nil
+		}
+		log.Printf("Send to dead letter topic %s failed, err: %v \n", h.deadLetterTopic, err)
+	}
+	return h.WriteFailRecord(file, record)
+}
@@ -0,0 +1,98 @@
+package services_test
+
+import (
+	"errors"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"kafka-repush/services"
+	"log"
+	"os"
+	"testing"
+)
+
+func TestDeadLetterEncode(t *testing.T) {
+	record := services.NewFailRecord([]byte(`{"topic":"orders"`),
+		services.Position{Source: "log.txt", Offset: 10, Line: 2}, errors.New("unexpected end of JSON input"))
+
+	msg, err := services.DeadLetter{Record: record}.Encode("dlq")
+	assert.Nil(t, err)
+	assert.Equal(t, "dlq", msg.Topic)
+	value, err := msg.Value.Encode()
+	assert.Nil(t, err)
+	assert.Equal(t, `{"topic":"orders"`, string(value))
+
+	headers := make(map[string]string)
+	for _, header := range msg.Headers {
+		headers[string(header.Key)] = string(header.Value)
+	}
+	assert.Equal(t, services.ErrorClassUnknown, headers[services.HeaderErrorClass])
+	assert.Equal(t, "unexpected end of JSON input", headers[services.HeaderError])
+	assert.Equal(t, "log.txt", headers[services.HeaderSource])
+	assert.Equal(t, "10", headers[services.HeaderOffset])
+	assert.Equal(t, "2", headers[services.HeaderLine])
+	assert.Equal(t, "1", headers[services.HeaderAttempts])
+	_, ok := headers[services.HeaderLength]
+	assert.False(t, ok)
+
+	record = services.NewFailRecord([]byte(`{"topic":"orders"`), services.Position{Source: "log.txt", Offset: 10, Line: 2},
+		&services.LineTooLongError{Size: 2000, MaxSize: 17})
+	msg, err = services.DeadLetter{Record: record}.Encode("dlq")
+	assert.Nil(t, err)
+	assert.Equal(t, services.HeaderLength, string(msg.Headers[len(msg.Headers)-1].Key))
+	assert.Equal(t, "2000", string(msg.Headers[len(msg.Headers)-1].Value))
+}
+
+func TestWriteFailure(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mockKafka := NewMockProducer(ctrl)
+	service := services.NewLogHandler(mockKafka)
+
+	record := services.NewFailRecord([]byte("testdata"), services.Position{}, errors.New("sending message failed"))
+	failErr := errors.New("sending message failed")
+
+	testCases := []struct {
+		name     string
+		topic    string
+		tearDown func()
+		written  bool
+	}{
+		{
+			name:     "Without dead letter topic",
+			tearDown: func() {},
+			written:  true,
+		},
+		{
+			name:  "Dead letter topic reachable",
+			topic: "dlq",
+			tearDown: func() {
+				mockKafka.EXPECT().Send("dlq", services.DeadLetter{Record: record}).Times(1).Return(nil)
+			},
+			written: false,
+		},
+		{
+			name:  "Dead letter topic unreachable",
+			topic: "dlq",
+			tearDown: func() {
+				mockKafka.EXPECT().Send("dlq", services.DeadLetter{Record: record}).Times(1).Return(failErr)
+			},
+			written: true,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			errorFile, err := ioutil.TempFile("", "error.*.txt")
+			if err != nil {
+				log.Fatal(err)
+			}
+			defer os.Remove(errorFile.Name())
+			defer errorFile.Close()
+
+			test.tearDown()
+			service.SetDeadLetterTopic(test.topic)
+			assert.Nil(t, service.WriteFailure(errorFile, record))
+			records := readFailRecords(errorFile.Name())
+			assert.Equal(t, test.written, len(records) == 1)
+		})
+	}
+}
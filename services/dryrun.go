@@ -0,0 +1,119 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/Shopify/sarama"
+)
+
+type (
+	// DryRunReport summarize lines which would be pushed, nothing is sent while it is collected
+	DryRunReport struct {
+		Lines         int
+		ParseFailures int
+		Rejected      int
+		Skipped       int
+		Oversize      int
+		Topics        map[string]*TopicSummary
+	}
+
+	// TopicSummary count messages which would be sent to a topic
+	TopicSummary struct {
+		Messages int
+		// Bytes is the size of keys, values and headers of messages
+		Bytes int64
+	}
+)
+
+//NewDryRunReport create empty dry run report
+func NewDryRunReport() *DryRunReport {
+	return &DryRunReport{Topics: make(map[string]*TopicSummary)}
+}
+
+//DryRunLine parse a log line and build its kafka message as PushLine would, message is counted
+//in report instead of being sent. Parse or topic error of the line is returned
+func (h *LogHandler) DryRunLine(report *DryRunReport, line []byte) error {
+	report.Lines++
+	logInfo, keep, err := h.parseLine(line, h.filter)
+	if err != nil {
+		var pushErr *PushError
+		switch {
+		case errors.As(err, &pushErr) && pushErr.Class == ErrorClassTopic:
+			report.Rejected++
+		case errors.As(err, &pushErr) && pushErr.Class == ErrorClassSize:
+			report.Oversize++
+		default:
+			report.ParseFailures++
+		}
+		return err
+	}
+	if !keep {
+		report.Skipped++
+		return nil
+	}
+	msg, err := encodeMessage(logInfo.Topic, logInfo)
+	if err != nil {
+		report.ParseFailures++
+		return &PushError{Class: ErrorClassParse, Topic: logInfo.Topic, Err: err}
+	}
+	size, err := messageSize(msg)
+	if err != nil {
+		report.ParseFailures++
+		return &PushError{Class: ErrorClassParse, Topic: logInfo.Topic, Err: err}
+	}
+
+	topic, ok := report.Topics[msg.Topic]
+	if !ok {
+		topic = &TopicSummary{}
+		report.Topics[msg.Topic] = topic
+	}
+	topic.Messages++
+	topic.Bytes += size
+	return nil
+}
+
+//Write print report with a line per topic sorted by name
+func (r *DryRunReport) Write(w io.Writer) error {
+	names := make([]string, 0, len(r.Topics))
+	var messages int
+	var bytes int64
+	for name, topic := range r.Topics {
+		names = append(names, name)
+		messages += topic.Messages
+		bytes += topic.Bytes
+	}
+	sort.Strings(names)
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "Lines: %d, skipped: %d, parse failures: %d, rejected: %d, oversize: %d\n",
+		r.Lines, r.Skipped, r.ParseFailures, r.Rejected, r.Oversize)
+	fmt.Fprintf(tw, "TOPIC\tMESSAGES\tBYTES\n")
+	for _, name := range names {
+		fmt.Fprintf(tw, "%s\t%d\t%d\n", name, r.Topics[name].Messages, r.Topics[name].Bytes)
+	}
+	fmt.Fprintf(tw, "TOTAL\t%d\t%d\n", messages, bytes)
+	return tw.Flush()
+}
+
+//messageSize get size of key, value and headers of kafka message
+func messageSize(msg *sarama.ProducerMessage) (int64, error) {
+	var size int64
+	for _, encoder := range []sarama.Encoder{msg.Key, msg.Value} {
+		if encoder == nil {
+			continue
+		}
+		data, err := encoder.Encode()
+		if err != nil {
+			return 0, err
+		}
+		size += int64(len(data))
+	}
+	for _, header := range msg.Headers {
+		size += int64(len(header.Key) + len(header.Value))
+	}
+	return size, nil
+}
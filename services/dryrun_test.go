@@ -0,0 +1,81 @@
+package services_test
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"kafka-repush/services"
+	"testing"
+)
+
+func TestDryRunLine(t *testing.T) {
+	testCases := []struct {
+		name      string
+		lines     []string
+		expReport *services.DryRunReport
+		expErrs   int
+	}{
+		{
+			name: "Messages counted per topic",
+			lines: []string{
+				`{"topic":"orders","message":"hello","key":"k1"}`,
+				`{"topic":"orders","message":"hi","headers":{"h":"v"}}`,
+				`{"topic":"users","message":"aGk=","encoding":"base64"}`,
+			},
+			expReport: &services.DryRunReport{
+				Lines: 3,
+				Topics: map[string]*services.TopicSummary{
+					"orders": {Messages: 2, Bytes: 11},
+					"users":  {Messages: 1, Bytes: 2},
+				},
+			},
+		},
+		{
+			name: "Parse failures",
+			lines: []string{
+				`not json`,
+				`{"topic":"orders","message":"%%%","encoding":"base64"}`,
+				`{"topic":"orders","message":"ok"}`,
+			},
+			expReport: &services.DryRunReport{
+				Lines:         3,
+				ParseFailures: 2,
+				Topics: map[string]*services.TopicSummary{
+					"orders": {Messages: 1, Bytes: 2},
+				},
+			},
+			expErrs: 2,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			service := services.NewLogHandler(nil)
+			report := services.NewDryRunReport()
+			errs := 0
+			for _, line := range tc.lines {
+				if err := service.DryRunLine(report, []byte(line)); err != nil {
+					errs++
+				}
+			}
+			assert.Equal(t, tc.expReport, report)
+			assert.Equal(t, tc.expErrs, errs)
+		})
+	}
+}
+
+func TestDryRunReportWrite(t *testing.T) {
+	report := &services.DryRunReport{
+		Lines:         4,
+		ParseFailures: 1,
+		Topics: map[string]*services.TopicSummary{
+			"users":  {Messages: 1, Bytes: 2},
+			"orders": {Messages: 2, Bytes: 11},
+		},
+	}
+	var out bytes.Buffer
+	assert.Nil(t, report.Write(&out))
+	assert.Equal(t, "Lines: 4, skipped: 0, parse failures: 1, rejected: 0, oversize: 0\n"+
+		"TOPIC   MESSAGES  BYTES\n"+
+		"orders  2         11\n"+
+		"users   1         2\n"+
+		"TOTAL   3         13\n", out.String())
+}
@@ -0,0 +1,190 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// Error classes of a failed push
+const (
+	ErrorClassParse     = "parse"
+	ErrorClassTopic     = "topic"
+	ErrorClassTransform = "transform"
+	ErrorClassSize      = "size"
+	ErrorClassBroker    = "broker"
+	ErrorClassUnknown   = "unknown"
+)
+
+type (
+	// Position locate a line in an input file
+	Position struct {
+		Source string `json:"source,omitempty"`
+		Offset int64  `json:"offset"`
+		Line   int64  `json:"lineNumber"`
+	}
+
+	// PushError is the error of a line failing to be pushed
+	PushError struct {
+		Class string
+		Topic string
+		Err   error
+	}
+
+	// FailRecord is an entry of error file describing a failed push. Line too long to be read whole
+	// hold its head only and its Length, it is read again from its position when retried
+	FailRecord struct {
+		Line string `json:"line"`
+		Position
+		Length   int64     `json:"length,omitempty"`
+		Topic    string    `json:"topic,omitempty"`
+		Class    string    `json:"class"`
+		Error    string    `json:"error,omitempty"`
+		Time     time.Time `json:"time"`
+		Attempts int       `json:"attempts"`
+	}
+
+	// FailReader read failure records of error file
+	FailReader struct {
+		reader *bufio.Reader
+	}
+)
+
+func (e *PushError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *PushError) Unwrap() error {
+	return e.Err
+}
+
+//NewFailRecord describe a line which failed to be pushed for the first time
+func NewFailRecord(line []byte, pos Position, err error) FailRecord {
+	record := FailRecord{
+		Line:     string(line),
+		Position: pos,
+		Time:     time.Now().UTC(),
+		Attempts: 1,
+	}
+	var tooLong *LineTooLongError
+	if errors.As(err, &tooLong) {
+		record.Length = tooLong.Size
+	}
+	record.setError(err)
+	return record
+}
+
+//FullLine get line of record, a line recorded with its head only is read again from input file and
+//must still start with the head
+func (r FailRecord) FullLine() ([]byte, error) {
+	if r.Length == 0 {
+		return []byte(r.Line), nil
+	}
+	if r.Source == "" {
+		return nil, errors.New("position of line too long is unknown")
+	}
+	line, err := ReadLineAt(r.Source, r.Offset, r.Length)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(line, []byte(r.Line)) {
+		return nil, fmt.Errorf("line at %s:%d has changed since it failed", r.Source, r.Offset)
+	}
+	return line, nil
+}
+
+//setError record error of the last push attempt
+func (r *FailRecord) setError(err error) {
+	r.Class, r.Error = ErrorClassUnknown, ""
+	if err == nil {
+		return
+	}
+	r.Error = err.Error()
+	var pushErr *PushError
+	if errors.As(err, &pushErr) {
+		r.Class = pushErr.Class
+		if pushErr.Topic != "" {
+			r.Topic = pushErr.Topic
+		}
+	}
+}
+
+//WriteFailRecord append a failure record to error file. It is written under the lock of error file, a retry
+//replacing error file in the meantime is followed by appending to the file now at its name
+func (h *LogHandler) WriteFailRecord(file *os.File, record FailRecord) error {
+	recordByte, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if file == nil {
+		return os.ErrInvalid
+	}
+	unlock, err := lockName(file.Name())
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	replaced, err := isReplaced(file)
+	if err != nil {
+		return err
+	}
+	if replaced {
+		return appendFile(file.Name(), append(recordByte, '\n'))
+	}
+	// Single write so concurrent writers never interleave records
+	if _, err := file.Write(append(recordByte, '\n')); err != nil {
+		return err
+	}
+	return nil
+}
+
+//isReplaced report whether open file is no longer the file at its name
+func isReplaced(file *os.File) (bool, error) {
+	current, err := file.Stat()
+	if err != nil {
+		return false, err
+	}
+	info, err := os.Stat(file.Name())
+	if os.IsNotExist(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return !os.SameFile(info, current), nil
+}
+
+//NewFailReader read failure records from r
+func NewFailReader(r io.Reader) *FailReader {
+	return &FailReader{reader: bufio.NewReader(r)}
+}
+
+//Read get next failure record, return io.EOF when there is nothing left to read.
+//Lines written before failures were structured are returned as records holding only the line
+func (f *FailReader) Read() (FailRecord, error) {
+	for {
+		line, err := f.reader.ReadBytes('\n')
+		line = bytes.TrimRight(line, "\r\n")
+		if len(line) == 0 {
+			if err != nil {
+				return FailRecord{}, err
+			}
+			continue
+		}
+		if err != nil && err != io.EOF {
+			return FailRecord{}, err
+		}
+
+		var record FailRecord
+		if json.Unmarshal(line, &record) != nil || (record.Line == "" && record.Class == "") {
+			return FailRecord{Line: string(line), Class: ErrorClassUnknown, Attempts: 1}, nil
+		}
+		return record, nil
+	}
+}
@@ -0,0 +1,86 @@
+package services_test
+
+import (
+	"errors"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"io/ioutil"
+	"kafka-repush/services"
+	"log"
+	"os"
+	"testing"
+)
+
+func TestFailRecord(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mockKafka := NewMockProducer(ctrl)
+	service := services.NewLogHandler(mockKafka)
+
+	errorFile, err := ioutil.TempFile("", "error.*.txt")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.Remove(errorFile.Name())
+	defer errorFile.Close()
+
+	logInfo := services.LogInfo{Topic: "testdata", Message: "testdata"}
+	failErr := errors.New("sending message failed")
+	mockKafka.EXPECT().Send(logInfo.Topic, logInfo).Times(1).Return(failErr)
+	pos := services.Position{Source: "log.txt", Offset: 10, Line: 2}
+
+	testCases := []struct {
+		name   string
+		input  string
+		class  string
+		topic  string
+		errMsg string
+	}{
+		{
+			name:   "Broker error",
+			input:  `{"topic":"testdata","message":"testdata"}`,
+			class:  services.ErrorClassBroker,
+			topic:  "testdata",
+			errMsg: failErr.Error(),
+		},
+		{
+			name:   "Parse error",
+			input:  `{"topic":"testdata"`,
+			class:  services.ErrorClassParse,
+			errMsg: "unexpected end of JSON input",
+		},
+	}
+	for _, test := range testCases {
+		err := service.PushLine([]byte(test.input))
+		record := services.NewFailRecord([]byte(test.input), pos, err)
+		assert.Nil(t, service.WriteFailRecord(errorFile, record))
+	}
+	// Line written before failures were structured
+	if _, err := errorFile.WriteString(`{"topic":"testdata","message":"legacy"}` + "\n"); err != nil {
+		log.Fatal(err)
+	}
+	if _, err := errorFile.Seek(0, 0); err != nil {
+		log.Fatal(err)
+	}
+
+	reader := services.NewFailReader(errorFile)
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			record, err := reader.Read()
+			assert.Nil(t, err)
+			assert.Equal(t, test.input, record.Line)
+			assert.Equal(t, pos, record.Position)
+			assert.Equal(t, test.class, record.Class)
+			assert.Equal(t, test.topic, record.Topic)
+			assert.Equal(t, test.errMsg, record.Error)
+			assert.Equal(t, 1, record.Attempts)
+			assert.False(t, record.Time.IsZero())
+		})
+	}
+	record, err := reader.Read()
+	assert.Nil(t, err)
+	assert.Equal(t, `{"topic":"testdata","message":"legacy"}`, record.Line)
+	assert.Equal(t, services.ErrorClassUnknown, record.Class)
+	_, err = reader.Read()
+	assert.Equal(t, io.EOF, err)
+}
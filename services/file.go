@@ -0,0 +1,73 @@
+package services
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+//writeFileAtomic replace file content through a synced temporary file, so readers see either old or new content
+func writeFileAtomic(name string, data []byte, perm os.FileMode) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(name), filepath.Base(name)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), name); err != nil {
+		return err
+	}
+	syncDir(filepath.Dir(name))
+	return nil
+}
+
+//writeFileWithBackup replace file content atomically, previous content is first kept as backup when it is valid
+func writeFileWithBackup(name string, backup string, data []byte, valid func([]byte) bool) error {
+	if previous, err := ioutil.ReadFile(name); err == nil && valid(previous) {
+		if err := writeFileAtomic(backup, previous, 0644); err != nil {
+			return err
+		}
+	}
+	return writeFileAtomic(name, data, 0644)
+}
+
+//syncDir flush directory entries so a rename survive power loss, not every platform support it
+func syncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+}
+
+//LockName get name of the lock file guarding given file
+func LockName(name string) string {
+	return name + ".lock"
+}
+
+//lockName take exclusive lock of file name through its lock file, so processes appending to and rewriting
+//the same file take turns. Returned function release the lock
+func lockName(name string) (func(), error) {
+	file, err := os.OpenFile(LockName(name), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(file); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return func() { file.Close() }, nil
+}
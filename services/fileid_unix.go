@@ -0,0 +1,18 @@
+//go:build !windows
+// +build !windows
+
+package services
+
+import (
+	"os"
+	"syscall"
+)
+
+//fileID get device and inode number of given file
+func fileID(file *os.File, info os.FileInfo) (uint64, uint64) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0
+	}
+	return uint64(stat.Dev), uint64(stat.Ino)
+}
@@ -0,0 +1,18 @@
+//go:build windows
+// +build windows
+
+package services
+
+import (
+	"os"
+	"syscall"
+)
+
+//fileID get volume serial number and file index of given file
+func fileID(file *os.File, info os.FileInfo) (uint64, uint64) {
+	var data syscall.ByHandleFileInformation
+	if err := syscall.GetFileInformationByHandle(syscall.Handle(file.Fd()), &data); err != nil {
+		return 0, 0
+	}
+	return uint64(data.VolumeSerialNumber), uint64(data.FileIndexHigh)<<32 | uint64(data.FileIndexLow)
+}
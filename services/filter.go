@@ -0,0 +1,515 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// TransformFilter is the type of transform skipping lines not matching a filter expression
+const TransformFilter = "filter"
+
+type (
+	// Filter is a compiled filter expression selecting lines to push. Expression compare fields
+	// with literals using ==, !=, <, <=, >, >= and =~ (regular expression), combined with &&, || and !.
+	// Fields are topic, key, partition, timestamp, encoding, message, headers.<name> and
+	// $.<path> for a field of JSON message body, a missing field is null. For example:
+	//
+	//	topic == "orders" && timestamp >= "2021-03-01T00:00:00Z" && $.order.total > 100
+	Filter struct {
+		expr string
+		root filterNode
+	}
+
+	filterNode interface {
+		eval(ctx *filterContext) bool
+	}
+
+	filterContext struct {
+		info   *LogInfo
+		body   map[string]interface{}
+		parsed bool
+	}
+
+	filterAnd struct{ left, right filterNode }
+	filterOr  struct{ left, right filterNode }
+	filterNot struct{ node filterNode }
+
+	// filterCompare compare two operands, a single operand is true when it is neither null, false,
+	// zero nor empty
+	filterCompare struct {
+		left, right filterOperand
+		op          string
+		re          *regexp.Regexp
+	}
+
+	filterOperand struct {
+		field   string
+		literal interface{}
+	}
+
+	filterToken struct {
+		kind  string
+		text  string
+		value interface{}
+	}
+
+	filterParser struct {
+		tokens []filterToken
+		pos    int
+	}
+)
+
+const (
+	tokenOp     = "op"
+	tokenField  = "field"
+	tokenValue  = "value"
+	tokenLParen = "("
+	tokenRParen = ")"
+	tokenEnd    = "end"
+)
+
+//NewFilter compile filter expression
+func NewFilter(expr string) (*Filter, error) {
+	tokens, err := tokenizeFilter(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid filter %q: %v", expr, err)
+	}
+	p := &filterParser{tokens: tokens}
+	root, err := p.parseOr()
+	if err == nil && p.peek().kind != tokenEnd {
+		err = fmt.Errorf("unexpected %q", p.peek().text)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid filter %q: %v", expr, err)
+	}
+	return &Filter{expr: expr, root: root}, nil
+}
+
+//String get filter expression
+func (f *Filter) String() string {
+	return f.expr
+}
+
+//Match report whether line match filter
+func (f *Filter) Match(info *LogInfo) bool {
+	return f.root.eval(&filterContext{info: info})
+}
+
+//Apply skip lines not matching filter
+func (f *Filter) Apply(info *LogInfo) (bool, error) {
+	return f.Match(info), nil
+}
+
+//SetFilter skip lines not matching filter, it is applied before transforms. Nil filter keep every line
+func (h *LogHandler) SetFilter(filter *Filter) {
+	h.filter = filter
+}
+
+func newFilterTransform(options json.RawMessage) (Transform, error) {
+	var t struct {
+		Expr string `json:"expr"`
+	}
+	if err := json.Unmarshal(options, &t); err != nil {
+		return nil, err
+	}
+	if t.Expr == "" {
+		return nil, errors.New("filter expr must be set")
+	}
+	return NewFilter(t.Expr)
+}
+
+func (n filterAnd) eval(ctx *filterContext) bool { return n.left.eval(ctx) && n.right.eval(ctx) }
+func (n filterOr) eval(ctx *filterContext) bool  { return n.left.eval(ctx) || n.right.eval(ctx) }
+func (n filterNot) eval(ctx *filterContext) bool { return !n.node.eval(ctx) }
+
+func (n filterCompare) eval(ctx *filterContext) bool {
+	left := n.left.value(ctx)
+	if n.op == "" {
+		return truthy(left)
+	}
+	if n.re != nil {
+		s, ok := left.(string)
+		return ok && n.re.MatchString(s)
+	}
+	right := n.right.value(ctx)
+
+	// Timestamp is compared with RFC 3339 strings or milliseconds since epoch
+	if t, ok := left.(time.Time); ok {
+		right = toTime(right)
+		if right == nil {
+			return n.op == "!="
+		}
+		return compareOrdered(n.op, t.Sub(right.(time.Time)))
+	}
+	if t, ok := right.(time.Time); ok {
+		left = toTime(left)
+		if left == nil {
+			return n.op == "!="
+		}
+		return compareOrdered(n.op, left.(time.Time).Sub(t))
+	}
+
+	switch l := left.(type) {
+	case float64:
+		if r, ok := right.(float64); ok {
+			return compareOrdered(n.op, l-r)
+		}
+	case string:
+		if r, ok := right.(string); ok {
+			return compareOrdered(n.op, strings.Compare(l, r))
+		}
+	}
+	switch n.op {
+	case "==":
+		return left == right
+	case "!=":
+		return left != right
+	}
+	return false
+}
+
+//compareOrdered tell result of comparison from sign of difference
+func compareOrdered(op string, diff interface{}) bool {
+	var sign int
+	switch d := diff.(type) {
+	case float64:
+		if d < 0 {
+			sign = -1
+		} else if d > 0 {
+			sign = 1
+		}
+	case time.Duration:
+		if d < 0 {
+			sign = -1
+		} else if d > 0 {
+			sign = 1
+		}
+	case int:
+		sign = d
+	}
+	switch op {
+	case "==":
+		return sign == 0
+	case "!=":
+		return sign != 0
+	case "<":
+		return sign < 0
+	case "<=":
+		return sign <= 0
+	case ">":
+		return sign > 0
+	case ">=":
+		return sign >= 0
+	}
+	return false
+}
+
+func truthy(v interface{}) bool {
+	switch v := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case float64:
+		return v != 0
+	case string:
+		return v != ""
+	case time.Time:
+		return !v.IsZero()
+	}
+	return true
+}
+
+func toTime(v interface{}) interface{} {
+	switch v := v.(type) {
+	case time.Time:
+		return v
+	case string:
+		t, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			return nil
+		}
+		return t
+	case float64:
+		return time.Unix(0, int64(v)*int64(time.Millisecond))
+	}
+	return nil
+}
+
+//value get literal or field value of line as nil, bool, float64, string or time.Time
+func (o filterOperand) value(ctx *filterContext) interface{} {
+	if o.field == "" {
+		return o.literal
+	}
+	info := ctx.info
+	switch {
+	case o.field == "topic":
+		return info.Topic
+	case o.field == "key":
+		if info.MessageKey == nil {
+			return nil
+		}
+		return *info.MessageKey
+	case o.field == "partition":
+		if info.Partition == nil {
+			return nil
+		}
+		return float64(*info.Partition)
+	case o.field == "timestamp":
+		if info.Timestamp.IsZero() {
+			return nil
+		}
+		return info.Timestamp
+	case o.field == "encoding":
+		return info.Encoding
+	case o.field == "message":
+		return info.Message
+	case strings.HasPrefix(o.field, "headers."):
+		name := strings.TrimPrefix(o.field, "headers.")
+		for _, header := range info.Headers {
+			if header.Key == name {
+				return header.Value
+			}
+		}
+		return nil
+	case strings.HasPrefix(o.field, "$."):
+		if !ctx.parsed {
+			ctx.parsed = true
+			ctx.body, _ = messageDocument(info)
+		}
+		if ctx.body == nil {
+			return nil
+		}
+		value, ok := lookupField(ctx.body, strings.TrimPrefix(o.field, "$."))
+		if !ok {
+			return nil
+		}
+		return filterValue(value)
+	}
+	return nil
+}
+
+//filterValue convert JSON value of message body, objects and arrays are compared as JSON text
+func filterValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case nil, bool, string:
+		return v
+	case json.Number:
+		f, err := v.Float64()
+		if err != nil {
+			return v.String()
+		}
+		return f
+	}
+	return fieldString(value)
+}
+
+func validFilterField(field string) bool {
+	switch field {
+	case "topic", "key", "partition", "timestamp", "encoding", "message":
+		return true
+	}
+	return (strings.HasPrefix(field, "headers.") && len(field) > len("headers.")) ||
+		(strings.HasPrefix(field, "$.") && len(field) > len("$."))
+}
+
+func (p *filterParser) peek() filterToken {
+	return p.tokens[p.pos]
+}
+
+func (p *filterParser) next() filterToken {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEnd {
+		p.pos++
+	}
+	return t
+}
+
+func (p *filterParser) parseOr() (filterNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == tokenOp && p.peek().text == "||" {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = filterOr{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseAnd() (filterNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == tokenOp && p.peek().text == "&&" {
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = filterAnd{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseUnary() (filterNode, error) {
+	t := p.peek()
+	switch {
+	case t.kind == tokenOp && t.text == "!":
+		p.next()
+		node, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return filterNot{node: node}, nil
+	case t.kind == tokenLParen:
+		p.next()
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.next().kind != tokenRParen {
+			return nil, errors.New("missing )")
+		}
+		return node, nil
+	}
+	return p.parseCompare()
+}
+
+func (p *filterParser) parseCompare() (filterNode, error) {
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	t := p.peek()
+	if t.kind != tokenOp || t.text == "&&" || t.text == "||" || t.text == "!" {
+		return filterCompare{left: left}, nil
+	}
+	p.next()
+	right, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	node := filterCompare{left: left, right: right, op: t.text}
+	if t.text == "=~" {
+		pattern, ok := right.literal.(string)
+		if right.field != "" || !ok {
+			return nil, errors.New("=~ must be followed by a string")
+		}
+		if node.re, err = regexp.Compile(pattern); err != nil {
+			return nil, err
+		}
+	}
+	return node, nil
+}
+
+func (p *filterParser) parseOperand() (filterOperand, error) {
+	t := p.next()
+	switch t.kind {
+	case tokenField:
+		if !validFilterField(t.text) {
+			return filterOperand{}, fmt.Errorf("unknown field %s", t.text)
+		}
+		return filterOperand{field: t.text}, nil
+	case tokenValue:
+		return filterOperand{literal: t.value}, nil
+	case tokenEnd:
+		return filterOperand{}, errors.New("unexpected end of expression")
+	}
+	return filterOperand{}, fmt.Errorf("unexpected %q", t.text)
+}
+
+func tokenizeFilter(expr string) ([]filterToken, error) {
+	var tokens []filterToken
+	i := 0
+	for i < len(expr) {
+		c := expr[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '(' || c == ')':
+			tokens = append(tokens, filterToken{kind: string(c), text: string(c)})
+			i++
+		case strings.ContainsRune("=!<>&|", rune(c)):
+			op := ""
+			for _, candidate := range []string{"==", "!=", "<=", ">=", "=~", "&&", "||", "<", ">", "!"} {
+				if strings.HasPrefix(expr[i:], candidate) {
+					op = candidate
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("unexpected %q at %d", c, i)
+			}
+			tokens = append(tokens, filterToken{kind: tokenOp, text: op})
+			i += len(op)
+		case c == '"' || c == '\'':
+			s, n, err := readFilterString(expr[i:])
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, filterToken{kind: tokenValue, text: expr[i : i+n], value: s})
+			i += n
+		case c == '-' || (c >= '0' && c <= '9'):
+			j := i + 1
+			for j < len(expr) && strings.ContainsRune("0123456789.eE+-", rune(expr[j])) {
+				j++
+			}
+			f, err := strconv.ParseFloat(expr[i:j], 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid number %s", expr[i:j])
+			}
+			tokens = append(tokens, filterToken{kind: tokenValue, text: expr[i:j], value: f})
+			i = j
+		case c == '$' || c == '_' || unicode.IsLetter(rune(c)):
+			j := i + 1
+			for j < len(expr) && (expr[j] == '.' || expr[j] == '_' || expr[j] == '-' || expr[j] == '$' ||
+				unicode.IsLetter(rune(expr[j])) || unicode.IsDigit(rune(expr[j]))) {
+				j++
+			}
+			word := expr[i:j]
+			switch word {
+			case "true":
+				tokens = append(tokens, filterToken{kind: tokenValue, text: word, value: true})
+			case "false":
+				tokens = append(tokens, filterToken{kind: tokenValue, text: word, value: false})
+			case "null":
+				tokens = append(tokens, filterToken{kind: tokenValue, text: word, value: nil})
+			default:
+				tokens = append(tokens, filterToken{kind: tokenField, text: word})
+			}
+			i = j
+		default:
+			return nil, fmt.Errorf("unexpected %q at %d", c, i)
+		}
+	}
+	return append(tokens, filterToken{kind: tokenEnd}), nil
+}
+
+//readFilterString read a quoted string, backslash escape quote and backslash only so regular
+//expressions are written as is
+func readFilterString(s string) (string, int, error) {
+	quote := s[0]
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		switch {
+		case s[i] == quote:
+			return b.String(), i + 1, nil
+		case s[i] == '\\' && i+1 < len(s) && (s[i+1] == quote || s[i+1] == '\\'):
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return "", 0, errors.New("unterminated string")
+}
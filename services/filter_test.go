@@ -0,0 +1,87 @@
+package services_test
+
+import (
+	"encoding/json"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"kafka-repush/services"
+	"log"
+	"testing"
+)
+
+func TestFilterMatch(t *testing.T) {
+	line := `{"topic":"orders","key":"o-1","partition":2,"timestamp":"2021-03-01T10:00:00Z",` +
+		`"headers":{"source":"web"},"message":"{\"order\":{\"total\":120.5,\"status\":\"paid\",\"tags\":[\"a\"]},\"test\":false}"}`
+	testCases := []struct {
+		expr     string
+		expMatch bool
+	}{
+		{expr: `topic == "orders"`, expMatch: true},
+		{expr: `topic != 'orders'`, expMatch: false},
+		{expr: `key =~ "^o-\d+$"`, expMatch: true},
+		{expr: `partition >= 2 && partition < 3`, expMatch: true},
+		{expr: `timestamp >= "2021-03-01T00:00:00Z" && timestamp < "2021-03-02T00:00:00Z"`, expMatch: true},
+		{expr: `timestamp > 1614592800000`, expMatch: false},
+		{expr: `headers.source == "web"`, expMatch: true},
+		{expr: `headers.missing == null`, expMatch: true},
+		{expr: `$.order.total > 100 && $.order.status == "paid"`, expMatch: true},
+		{expr: `$.order.tags == "[\"a\"]"`, expMatch: true},
+		{expr: `$.test || $.order.missing`, expMatch: false},
+		{expr: `!$.test && !($.order.status == "new" || topic == "users")`, expMatch: true},
+		{expr: `$.order.total > "100"`, expMatch: false},
+	}
+	var info services.LogInfo
+	if err := json.Unmarshal([]byte(line), &info); err != nil {
+		log.Fatal(err)
+	}
+	for _, tc := range testCases {
+		t.Run(tc.expr, func(t *testing.T) {
+			filter, err := services.NewFilter(tc.expr)
+			if assert.Nil(t, err) {
+				assert.Equal(t, tc.expMatch, filter.Match(&info))
+			}
+		})
+	}
+}
+
+func TestNewFilterInvalid(t *testing.T) {
+	testCases := []struct {
+		expr   string
+		expErr string
+	}{
+		{expr: `topic ==`, expErr: `invalid filter "topic ==": unexpected end of expression`},
+		{expr: `(topic == "a"`, expErr: `invalid filter "(topic == \"a\"": missing )`},
+		{expr: `size > 1`, expErr: `invalid filter "size > 1": unknown field size`},
+		{expr: `topic == "a`, expErr: `invalid filter "topic == \"a": unterminated string`},
+		{expr: `topic =~ key`, expErr: `invalid filter "topic =~ key": =~ must be followed by a string`},
+		{expr: `topic = "a"`, expErr: `invalid filter "topic = \"a\"": unexpected '=' at 6`},
+		{expr: `topic == "a" "b"`, expErr: `invalid filter "topic == \"a\" \"b\"": unexpected "\"b\""`},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.expr, func(t *testing.T) {
+			_, err := services.NewFilter(tc.expr)
+			if assert.NotNil(t, err) {
+				assert.Equal(t, tc.expErr, err.Error())
+			}
+		})
+	}
+}
+
+func TestPushLineFilter(t *testing.T) {
+	filter, err := services.NewFilter(`$.status == "paid"`)
+	if err != nil {
+		log.Fatal(err)
+	}
+	ctrl := gomock.NewController(t)
+	mockKafka := NewMockProducer(ctrl)
+	mockKafka.EXPECT().Send("orders", gomock.Any()).Return(nil).Times(1)
+	service := services.NewLogHandler(mockKafka)
+	service.SetFilter(filter)
+
+	assert.Nil(t, service.PushLine([]byte(`{"topic":"orders","message":"{\"status\":\"paid\"}"}`)))
+	assert.Nil(t, service.PushLine([]byte(`{"topic":"orders","message":"{\"status\":\"new\"}"}`)))
+
+	report := services.NewDryRunReport()
+	assert.Nil(t, service.DryRunLine(report, []byte(`{"topic":"orders","message":"{\"status\":\"new\"}"}`)))
+	assert.Equal(t, 1, report.Skipped)
+}
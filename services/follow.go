@@ -0,0 +1,90 @@
+package services
+
+import (
+	"errors"
+	"io"
+	"os"
+	"time"
+)
+
+//Follow read lines appended to input file like tail -F until stop is closed,
+//every line is passed to handle together with its position and the checkpoint right after it.
+//A line longer than maxLineSize is passed with its head only and a LineTooLongError, zero maxLineSize is no limit.
+//Input file is reopened when it is rotated and waited for when it does not exist yet.
+//Follow return once input file is deleted and drained, so caller can drop it or follow it again
+func Follow(name string, cp Checkpoint, interval time.Duration, maxLineSize int, stop <-chan struct{},
+	handle func(line []byte, pos Position, cp Checkpoint, err error)) error {
+	w := newWatcher(name, interval)
+	defer w.close()
+
+	var reader *LogReader
+	defer func() {
+		if reader != nil {
+			reader.Close()
+		}
+	}()
+	for {
+		if reader == nil {
+			r, err := OpenLogReader(name, cp)
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			if reader = r; reader != nil {
+				reader.SetMaxLineSize(maxLineSize)
+				cp = reader.Checkpoint()
+			}
+		}
+		if reader != nil {
+			for {
+				select {
+				case <-stop:
+					return nil
+				default:
+				}
+				line, err := reader.ReadLine()
+				if err == io.EOF {
+					break
+				}
+				var tooLong *LineTooLongError
+				if err != nil && !errors.As(err, &tooLong) {
+					return err
+				}
+				cp = reader.Checkpoint()
+				handle(line, reader.Position(), cp, err)
+			}
+
+			rotated, err := reader.Rotated()
+			if err != nil {
+				return err
+			}
+			if rotated {
+				reader.Close()
+				reader = nil
+				continue
+			}
+			missing, err := reader.Missing()
+			if err != nil {
+				return err
+			}
+			if missing {
+				// Released so disk space of a deleted file is freed
+				reader.Close()
+				reader = nil
+				// Input file renamed by rotation is waited for, it is reopened from the segment holding checkpoint
+				path, err := findSegment(name, cp)
+				if err != nil {
+					return err
+				}
+				if path == "" {
+					return nil
+				}
+			}
+		}
+
+		select {
+		case <-stop:
+			return nil
+		case <-w.events:
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package services_test
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"kafka-repush/services"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFollow(t *testing.T) {
+	dir, err := ioutil.TempDir("", "follow")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "log.txt")
+	appendFile(name, "line 1\n")
+
+	lines := make(chan string, 10)
+	stop := make(chan struct{})
+	done := make(chan error, 1)
+	var cp services.Checkpoint
+	go func() {
+		done <- services.Follow(name, services.Checkpoint{}, 50*time.Millisecond, 0, stop, func(line []byte, pos services.Position, c services.Checkpoint, err error) {
+			cp = c
+			lines <- string(line)
+		})
+	}()
+
+	next := func() string {
+		select {
+		case line := <-lines:
+			return line
+		case <-time.After(5 * time.Second):
+			return "timeout"
+		}
+	}
+
+	testCases := []struct {
+		name   string
+		write  func()
+		output []string
+	}{
+		{
+			name:   "Read existing lines",
+			write:  func() {},
+			output: []string{"line 1"},
+		},
+		{
+			name: "Read appended lines",
+			write: func() {
+				appendFile(name, "line 2\n")
+			},
+			output: []string{"line 2"},
+		},
+		{
+			name: "Follow rotated file",
+			write: func() {
+				appendFile(name, "line 3\n")
+				if err := os.Rename(name, name+".1"); err != nil {
+					log.Fatal(err)
+				}
+				appendFile(name, "new 1\n")
+			},
+			output: []string{"line 3", "new 1"},
+		},
+		{
+			name: "Drain deleted file",
+			write: func() {
+				appendFile(name, "new 2\n")
+				if err := os.Remove(name); err != nil {
+					log.Fatal(err)
+				}
+			},
+			output: []string{"new 2"},
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			test.write()
+			var output []string
+			for range test.output {
+				output = append(output, next())
+			}
+			assert.Equal(t, test.output, output)
+		})
+	}
+
+	// Follow return once deleted file is drained
+	select {
+	case err := <-done:
+		assert.Nil(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("follow still running after input file is deleted")
+	}
+	close(stop)
+	assert.Equal(t, int64(12), cp.Offset)
+	assert.Equal(t, "", cp.Segment)
+}
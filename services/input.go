@@ -0,0 +1,96 @@
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+//ResolveInputs find input files of given pattern which is a file name, a glob or a directory.
+//Directories are listed, recursively when asked. Excluded files and rotated segments named after
+//another input file are left out, a file name without glob characters is kept even when it does not exist yet
+func ResolveInputs(pattern string, recursive bool, exclude ...string) ([]string, error) {
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, err
+	}
+	if len(matches) == 0 && !strings.ContainsAny(pattern, "*?[") {
+		matches = []string{pattern}
+	}
+
+	var names []string
+	for _, match := range matches {
+		info, err := os.Stat(match)
+		switch {
+		case err == nil && info.IsDir():
+			found, err := listDir(match, recursive)
+			if err != nil {
+				return nil, err
+			}
+			names = append(names, found...)
+		case err == nil && !info.Mode().IsRegular():
+			continue
+		default:
+			names = append(names, match)
+		}
+	}
+
+	skip := make(map[string]bool)
+	for _, name := range exclude {
+		if abs, err := filepath.Abs(name); err == nil {
+			skip[abs] = true
+		}
+	}
+	var inputs []string
+	for _, name := range names {
+		abs, err := filepath.Abs(name)
+		if err != nil {
+			return nil, err
+		}
+		if !skip[abs] {
+			skip[abs] = true
+			inputs = append(inputs, abs)
+		}
+	}
+
+	var files []string
+	for _, name := range inputs {
+		if !isSegmentOf(inputs, name) {
+			files = append(files, name)
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+//listDir list regular files in directory
+func listDir(dir string, recursive bool) ([]string, error) {
+	var files []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if path != dir && !recursive {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.Mode().IsRegular() {
+			files = append(files, path)
+		}
+		return nil
+	})
+	return files, err
+}
+
+//isSegmentOf report whether file is a rotated segment of another input file, it is drained through that file
+func isSegmentOf(inputs []string, name string) bool {
+	for _, input := range inputs {
+		if input != name && isRotatedName(input, name) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,93 @@
+package services_test
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"kafka-repush/services"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolveInputs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "input")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	dir, err = filepath.EvalSymlinks(dir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, sub := range []string{"sub", "daily"} {
+		if err := os.Mkdir(filepath.Join(dir, sub), 0755); err != nil {
+			log.Fatal(err)
+		}
+	}
+	for _, name := range []string{"a.log", "a.log.1", "b.log", "conf.json", "sub/c.log",
+		"daily/app.log.2021-01-01", "daily/app.log.2021-01-02", "daily/pod.log", "daily/pod-2.log"} {
+		appendFile(filepath.Join(dir, name), "line 1\n")
+	}
+	path := func(name string) string {
+		return filepath.Join(dir, name)
+	}
+
+	testCases := []struct {
+		name      string
+		pattern   string
+		recursive bool
+		output    []string
+	}{
+		{
+			name:    "Single file",
+			pattern: path("b.log"),
+			output:  []string{path("b.log")},
+		},
+		{
+			name:    "Missing file",
+			pattern: path("d.log"),
+			output:  []string{path("d.log")},
+		},
+		{
+			name:    "Glob",
+			pattern: path("*.log*"),
+			output:  []string{path("a.log"), path("b.log")},
+		},
+		{
+			name:    "Glob without match",
+			pattern: path("*.txt"),
+			output:  nil,
+		},
+		{
+			name:    "Directory",
+			pattern: dir,
+			output:  []string{path("a.log"), path("b.log")},
+		},
+		{
+			name:      "Recursive directory",
+			pattern:   dir,
+			recursive: true,
+			output: []string{path("a.log"), path("b.log"), path("daily/app.log.2021-01-01"), path("daily/app.log.2021-01-02"),
+				path("daily/pod-2.log"), path("daily/pod.log"), path("sub/c.log")},
+		},
+		{
+			name:    "Dated per-day files",
+			pattern: path("daily/app.log.*"),
+			output:  []string{path("daily/app.log.2021-01-01"), path("daily/app.log.2021-01-02")},
+		},
+		{
+			name:    "Directory of dated per-day files",
+			pattern: path("daily"),
+			output: []string{path("daily/app.log.2021-01-01"), path("daily/app.log.2021-01-02"),
+				path("daily/pod-2.log"), path("daily/pod.log")},
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			inputs, err := services.ResolveInputs(test.pattern, test.recursive, path("conf.json"))
+			assert.Nil(t, err)
+			assert.Equal(t, test.output, inputs)
+		})
+	}
+}
@@ -2,6 +2,7 @@ package services
 
 import (
 	"encoding/json"
+	"errors"
 	"log"
 
 	"github.com/Shopify/sarama"
@@ -19,31 +20,40 @@ type (
 	ProducerMessage interface {
 		Key() string
 	}
+
+	// MessageEncoder is a message building its own kafka message, such as one carrying headers
+	MessageEncoder interface {
+		Encode(topic string) (*sarama.ProducerMessage, error)
+	}
 )
 
 // NewProducer create new kafka producer with given brokers
 func NewProducer(brokers []string) (*KafkaProducer, error) {
-	config := sarama.NewConfig()
-	config.Producer.Partitioner = sarama.NewRandomPartitioner
-	config.Producer.RequiredAcks = sarama.WaitForAll
-	config.Producer.Return.Successes = true
+	config, err := ProducerConfig{}.SaramaConfig()
+	if err != nil {
+		return nil, err
+	}
 	producer, err := sarama.NewSyncProducer(brokers, config)
 
-	return &KafkaProducer{Prod: producer}, err
+	return &KafkaProducer{Prod: producer}, producerError(err)
+}
+
+//producerError map error of sarama producer creation onto errors of services, unreachable
+//brokers are ErrKafkaNotFound
+func producerError(err error) error {
+	if errors.Is(err, sarama.ErrOutOfBrokers) {
+		return ErrKafkaNotFound
+	}
+	return err
 }
 
 //Send send message to kafka server
 func (k *KafkaProducer) Send(topic string, msg ProducerMessage) error {
 	//Sending to kafka server
-	jsonMsg, err := json.Marshal(msg.Key())
+	kafkaMsg, err := encodeMessage(topic, msg)
 	if err != nil {
 		return err
 	}
-	kafkaMsg := &sarama.ProducerMessage{
-		Topic:     topic,
-		Partition: -1,
-		Value:     sarama.StringEncoder(jsonMsg),
-	}
 	_, _, err = k.Prod.SendMessage(kafkaMsg)
 
 	if err == nil {
@@ -52,6 +62,22 @@ func (k *KafkaProducer) Send(topic string, msg ProducerMessage) error {
 	return err
 }
 
+//encodeMessage build kafka message of given message
+func encodeMessage(topic string, msg ProducerMessage) (*sarama.ProducerMessage, error) {
+	if encoder, ok := msg.(MessageEncoder); ok {
+		return encoder.Encode(topic)
+	}
+	jsonMsg, err := json.Marshal(msg.Key())
+	if err != nil {
+		return nil, err
+	}
+	return &sarama.ProducerMessage{
+		Topic:     topic,
+		Partition: -1,
+		Value:     sarama.StringEncoder(jsonMsg),
+	}, nil
+}
+
 //Close close kafka server
 func (k *KafkaProducer) Close() error {
 	return k.Prod.Close()
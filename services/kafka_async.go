@@ -0,0 +1,176 @@
+package services
+
+import (
+	"sync"
+
+	"github.com/Shopify/sarama"
+)
+
+type (
+	// AsyncProducer is a producer sending messages in the background, done is called once
+	// message is delivered or failed
+	AsyncProducer interface {
+		Producer
+		SendAsync(topic string, msg ProducerMessage, done func(error))
+		Flush() error
+	}
+
+	// AsyncKafkaProducer send messages to kafka server in batches
+	AsyncKafkaProducer struct {
+		Prod     sarama.AsyncProducer
+		inFlight sync.WaitGroup
+		queue    *callbackQueue
+		closed   chan struct{}
+	}
+
+	// asyncResult is the metadata of a message telling who is waiting for it
+	asyncResult struct {
+		done func(error)
+		// direct callbacks only hand over the result, they are called without queueing
+		direct bool
+	}
+
+	// callbackQueue is an unbounded queue of callbacks, so slow callbacks never block collecting results
+	callbackQueue struct {
+		mu     sync.Mutex
+		cond   *sync.Cond
+		items  []func()
+		closed bool
+	}
+)
+
+//NewAsyncKafkaProducer report delivery of messages sent through given sarama producer,
+//producer must return both successes and errors
+func NewAsyncKafkaProducer(producer sarama.AsyncProducer) *AsyncKafkaProducer {
+	k := &AsyncKafkaProducer{
+		Prod:   producer,
+		queue:  newCallbackQueue(),
+		closed: make(chan struct{}),
+	}
+	go k.collect()
+	go k.dispatch()
+	return k
+}
+
+//Send send message and wait until it is delivered
+func (k *AsyncKafkaProducer) Send(topic string, msg ProducerMessage) error {
+	result := make(chan error, 1)
+	k.send(topic, msg, &asyncResult{done: func(err error) { result <- err }, direct: true})
+	return <-result
+}
+
+//SendAsync queue message for sending, done is called once it is delivered or failed.
+//Callbacks are called one at a time and may send messages but must not call Flush
+func (k *AsyncKafkaProducer) SendAsync(topic string, msg ProducerMessage, done func(error)) {
+	k.send(topic, msg, &asyncResult{done: done})
+}
+
+func (k *AsyncKafkaProducer) send(topic string, msg ProducerMessage, result *asyncResult) {
+	kafkaMsg, err := encodeMessage(topic, msg)
+	if err != nil {
+		result.done(err)
+		return
+	}
+	kafkaMsg.Metadata = result
+	k.inFlight.Add(1)
+	k.Prod.Input() <- kafkaMsg
+}
+
+//Flush wait until every queued message is done and its callback returned
+func (k *AsyncKafkaProducer) Flush() error {
+	k.inFlight.Wait()
+	return nil
+}
+
+//Close send queued messages and close kafka producer
+func (k *AsyncKafkaProducer) Close() error {
+	k.Prod.AsyncClose()
+	<-k.closed
+	return nil
+}
+
+//collect hand over delivery results of messages
+func (k *AsyncKafkaProducer) collect() {
+	successes, errs := k.Prod.Successes(), k.Prod.Errors()
+	for successes != nil || errs != nil {
+		select {
+		case msg, ok := <-successes:
+			if !ok {
+				successes = nil
+				continue
+			}
+			k.report(msg, nil)
+		case perr, ok := <-errs:
+			if !ok {
+				errs = nil
+				continue
+			}
+			k.report(perr.Msg, perr.Err)
+		}
+	}
+	k.queue.close()
+}
+
+func (k *AsyncKafkaProducer) report(msg *sarama.ProducerMessage, err error) {
+	result, ok := msg.Metadata.(*asyncResult)
+	if !ok {
+		return
+	}
+	if result.direct {
+		result.done(err)
+		k.inFlight.Done()
+		return
+	}
+	k.queue.push(func() {
+		result.done(err)
+		k.inFlight.Done()
+	})
+}
+
+//dispatch call queued callbacks in order
+func (k *AsyncKafkaProducer) dispatch() {
+	defer close(k.closed)
+	for {
+		callback, ok := k.queue.pop()
+		if !ok {
+			return
+		}
+		callback()
+	}
+}
+
+func newCallbackQueue() *callbackQueue {
+	q := &callbackQueue{}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+func (q *callbackQueue) push(callback func()) {
+	q.mu.Lock()
+	q.items = append(q.items, callback)
+	q.mu.Unlock()
+	q.cond.Signal()
+}
+
+//pop wait for next callback, return false once queue is closed and empty
+func (q *callbackQueue) pop() (func(), bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for len(q.items) == 0 && !q.closed {
+		q.cond.Wait()
+	}
+	if len(q.items) == 0 {
+		return nil, false
+	}
+	callback := q.items[0]
+	q.items[0] = nil
+	q.items = q.items[1:]
+	return callback, true
+}
+
+func (q *callbackQueue) close() {
+	q.mu.Lock()
+	q.closed = true
+	q.mu.Unlock()
+	q.cond.Broadcast()
+}
@@ -0,0 +1,103 @@
+package services_test
+
+import (
+	"errors"
+	"github.com/Shopify/sarama"
+	"github.com/Shopify/sarama/mocks"
+	"github.com/stretchr/testify/assert"
+	"kafka-repush/services"
+	"sync"
+	"testing"
+)
+
+func newMockAsyncProducer(t *testing.T) *mocks.AsyncProducer {
+	config := sarama.NewConfig()
+	config.Producer.Return.Successes = true
+	return mocks.NewAsyncProducer(t, config)
+}
+
+func TestAsyncKafkaProducerSendAsync(t *testing.T) {
+	mockKafka := newMockAsyncProducer(t)
+	mockKafka.ExpectInputAndSucceed()
+	mockKafka.ExpectInputAndFail(sarama.ErrNotLeaderForPartition)
+	mockKafka.ExpectInputAndSucceed()
+	producer := services.NewAsyncKafkaProducer(mockKafka)
+
+	var mu sync.Mutex
+	results := make(map[string]error)
+	for _, message := range []string{"first", "second", "third"} {
+		message := message
+		producer.SendAsync("test", services.LogInfo{Topic: "test", Message: message}, func(err error) {
+			mu.Lock()
+			results[message] = err
+			mu.Unlock()
+		})
+	}
+	assert.Nil(t, producer.Flush())
+
+	assert.Len(t, results, 3)
+	assert.Nil(t, results["first"])
+	assert.Equal(t, sarama.ErrNotLeaderForPartition, results["second"])
+	assert.Nil(t, results["third"])
+	assert.Nil(t, producer.Close())
+}
+
+func TestAsyncKafkaProducerSend(t *testing.T) {
+	mockKafka := newMockAsyncProducer(t)
+	mockKafka.ExpectInputAndSucceed()
+	mockKafka.ExpectInputAndFail(sarama.ErrRequestTimedOut)
+	producer := services.NewAsyncKafkaProducer(mockKafka)
+
+	assert.Nil(t, producer.Send("test", services.LogInfo{Topic: "test", Message: "first"}))
+	assert.Equal(t, sarama.ErrRequestTimedOut, producer.Send("test", services.LogInfo{Topic: "test", Message: "second"}))
+	assert.Nil(t, producer.Close())
+}
+
+func TestPushLineAsync(t *testing.T) {
+	mockKafka := newMockAsyncProducer(t)
+	mockKafka.ExpectInputAndSucceed()
+	mockKafka.ExpectInputAndFail(sarama.ErrRequestTimedOut)
+	service := services.NewLogHandler(services.NewAsyncKafkaProducer(mockKafka))
+
+	testCases := []struct {
+		name  string
+		line  string
+		class string
+	}{
+		{
+			name: "Delivered",
+			line: `{"topic":"test","message":"delivered"}`,
+		},
+		{
+			name:  "Broker failure",
+			line:  `{"topic":"test","message":"failed"}`,
+			class: services.ErrorClassBroker,
+		},
+		{
+			name:  "Parse failure",
+			line:  `{"topic":"test"`,
+			class: services.ErrorClassParse,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result := make(chan error, 1)
+			service.PushLineAsync([]byte(tc.line), func(err error) { result <- err })
+			assert.Nil(t, service.Flush())
+			err := <-result
+			if tc.class == "" {
+				assert.Nil(t, err)
+				return
+			}
+			var pushErr *services.PushError
+			assert.True(t, errors.As(err, &pushErr))
+			assert.Equal(t, tc.class, pushErr.Class)
+		})
+	}
+	assert.Nil(t, service.Close())
+}
+
+func TestNewProducerFromConfigInvalid(t *testing.T) {
+	_, err := services.NewProducerFromConfig([]string{"localhost:9092"}, services.ProducerConfig{Compression: "brotli"})
+	assert.NotNil(t, err)
+}
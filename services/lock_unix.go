@@ -0,0 +1,19 @@
+//go:build !windows
+// +build !windows
+
+package services
+
+import (
+	"os"
+	"syscall"
+)
+
+//lockFile take an exclusive lock on file, waiting for other holders to release it
+func lockFile(file *os.File) error {
+	for {
+		err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX)
+		if err != syscall.EINTR {
+			return err
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package services
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// Encodings of LogInfo message
+const (
+	EncodingString = "string"
+	EncodingJSON   = "json"
+	EncodingBase64 = "base64"
+)
+
+type (
+	// Header is a kafka record header
+	Header struct {
+		Key   string `json:"key"`
+		Value string `json:"value"`
+	}
+
+	// Headers is a list of headers which is read from a JSON object or a list of key value objects
+	Headers []Header
+)
+
+//UnmarshalJSON read headers from {"name": "value"} or [{"key": "name", "value": "value"}], order is kept
+func (h *Headers) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if bytes.Equal(data, []byte("null")) {
+		*h = nil
+		return nil
+	}
+	if len(data) > 0 && data[0] == '[' {
+		var headers []Header
+		if err := json.Unmarshal(data, &headers); err != nil {
+			return err
+		}
+		*h = headers
+		return nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	if token, err := decoder.Token(); err != nil || token != json.Delim('{') {
+		return errors.New("headers must be a JSON object or a list")
+	}
+	var headers Headers
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+		var value json.RawMessage
+		if err := decoder.Decode(&value); err != nil {
+			return err
+		}
+		header := Header{Key: token.(string), Value: string(value)}
+		if len(value) > 0 && value[0] == '"' {
+			if err := json.Unmarshal(value, &header.Value); err != nil {
+				return err
+			}
+		}
+		headers = append(headers, header)
+	}
+	*h = headers
+	return nil
+}
+
+//parseTimestamp read a timestamp given as RFC 3339 string or as milliseconds since epoch
+func parseTimestamp(data json.RawMessage) (time.Time, error) {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 || bytes.Equal(data, []byte("null")) {
+		return time.Time{}, nil
+	}
+	if data[0] == '"' {
+		var timestamp time.Time
+		err := json.Unmarshal(data, &timestamp)
+		return timestamp, err
+	}
+	millis, err := strconv.ParseInt(string(data), 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid timestamp %s", data)
+	}
+	return time.Unix(0, millis*int64(time.Millisecond)).UTC(), nil
+}
+
+//UnmarshalJSON accept message as a string or as any other JSON value, which is kept as its JSON text
+func (r *LogInfo) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		Topic     string          `json:"topic"`
+		Message   json.RawMessage `json:"message"`
+		Encoding  string          `json:"encoding"`
+		Key       *string         `json:"key"`
+		Headers   Headers         `json:"headers"`
+		Partition *int32          `json:"partition"`
+		Timestamp json.RawMessage `json:"timestamp"`
+		Null      bool            `json:"null"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	timestamp, err := parseTimestamp(raw.Timestamp)
+	if err != nil {
+		return err
+	}
+	*r = LogInfo{
+		Topic:      raw.Topic,
+		Encoding:   raw.Encoding,
+		MessageKey: raw.Key,
+		Headers:    raw.Headers,
+		Partition:  raw.Partition,
+		Timestamp:  timestamp,
+		Null:       raw.Null,
+	}
+
+	message := bytes.TrimSpace(raw.Message)
+	switch {
+	case len(message) == 0 || bytes.Equal(message, []byte("null")):
+	case message[0] == '"':
+		return json.Unmarshal(message, &r.Message)
+	default:
+		if r.Encoding == "" {
+			r.Encoding = EncodingJSON
+		}
+		if r.Encoding != EncodingJSON {
+			return fmt.Errorf("%s message must be a JSON string", r.Encoding)
+		}
+		r.Message = string(message)
+	}
+	return nil
+}
+
+//Value get bytes of message to deliver according to its encoding
+func (r LogInfo) Value() ([]byte, error) {
+	switch r.Encoding {
+	case "", EncodingString:
+		return []byte(r.Message), nil
+	case EncodingJSON:
+		if !json.Valid([]byte(r.Message)) {
+			return nil, errors.New("json message is not valid JSON")
+		}
+		return []byte(r.Message), nil
+	case EncodingBase64:
+		return base64.StdEncoding.DecodeString(r.Message)
+	default:
+		return nil, fmt.Errorf("unknown message encoding %q", r.Encoding)
+	}
+}
+
+//Encode build kafka message delivering message bytes as they are, with key, headers,
+//partition and timestamp of the log line when it has them. Null message has no value
+func (r LogInfo) Encode(topic string) (*sarama.ProducerMessage, error) {
+	msg := &sarama.ProducerMessage{
+		Topic:     topic,
+		Partition: -1,
+		Timestamp: r.Timestamp,
+	}
+	if !r.Null {
+		value, err := r.Value()
+		if err != nil {
+			return nil, err
+		}
+		msg.Value = sarama.ByteEncoder(value)
+	}
+	if r.MessageKey != nil {
+		msg.Key = sarama.StringEncoder(*r.MessageKey)
+	}
+	if r.Partition != nil {
+		if *r.Partition < 0 {
+			return nil, fmt.Errorf("invalid partition %d", *r.Partition)
+		}
+		msg.Partition = *r.Partition
+	}
+	for _, header := range r.Headers {
+		msg.Headers = append(msg.Headers, sarama.RecordHeader{Key: []byte(header.Key), Value: []byte(header.Value)})
+	}
+	return msg, nil
+}
+
+//SetMessageEncoding set encoding of messages whose line does not tell it
+func (h *LogHandler) SetMessageEncoding(encoding string) error {
+	switch encoding {
+	case "", EncodingString, EncodingJSON, EncodingBase64:
+		h.encoding = encoding
+		return nil
+	default:
+		return fmt.Errorf("unknown message encoding %q", encoding)
+	}
+}
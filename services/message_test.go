@@ -0,0 +1,178 @@
+package services_test
+
+import (
+	"encoding/json"
+	"github.com/Shopify/sarama"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"kafka-repush/services"
+	"testing"
+	"time"
+)
+
+func TestPushLineEncoding(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mockKafka := NewMockProducer(ctrl)
+	service := services.NewLogHandler(mockKafka)
+
+	testCases := []struct {
+		name     string
+		input    string
+		encoding string
+		class    string
+		output   string
+	}{
+		{
+			name:   "String message",
+			input:  `{"topic":"testdata","message":"say \"hi\"\n"}`,
+			output: "say \"hi\"\n",
+		},
+		{
+			name:   "JSON object message",
+			input:  `{"topic":"testdata","message":{"id": 1, "tags":["a"]}}`,
+			output: `{"id": 1, "tags":["a"]}`,
+		},
+		{
+			name:   "Base64 message",
+			input:  `{"topic":"testdata","message":"AAH/","encoding":"base64"}`,
+			output: "\x00\x01\xff",
+		},
+		{
+			name:     "Base64 message by config",
+			input:    `{"topic":"testdata","message":"aGVsbG8="}`,
+			encoding: services.EncodingBase64,
+			output:   "hello",
+		},
+		{
+			name:   "JSON string message",
+			input:  `{"topic":"testdata","message":"{\"id\":1}","encoding":"json"}`,
+			output: `{"id":1}`,
+		},
+		{
+			name:  "Invalid base64 message",
+			input: `{"topic":"testdata","message":"not base64!","encoding":"base64"}`,
+			class: services.ErrorClassParse,
+		},
+		{
+			name:  "Object message with base64 encoding",
+			input: `{"topic":"testdata","message":{"id":1},"encoding":"base64"}`,
+			class: services.ErrorClassParse,
+		},
+		{
+			name:  "Unknown encoding",
+			input: `{"topic":"testdata","message":"x","encoding":"hex"}`,
+			class: services.ErrorClassParse,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Nil(t, service.SetMessageEncoding(test.encoding))
+			var sent services.ProducerMessage
+			if test.class == "" {
+				mockKafka.EXPECT().Send("testdata", gomock.Any()).Times(1).DoAndReturn(
+					func(topic string, msg services.ProducerMessage) error {
+						sent = msg
+						return nil
+					})
+			}
+
+			err := service.PushLine([]byte(test.input))
+			if test.class != "" {
+				record := services.NewFailRecord([]byte(test.input), services.Position{}, err)
+				assert.Equal(t, test.class, record.Class)
+				return
+			}
+			assert.Nil(t, err)
+			encoder, ok := sent.(services.MessageEncoder)
+			assert.True(t, ok)
+			msg, err := encoder.Encode("testdata")
+			assert.Nil(t, err)
+			value, err := msg.Value.Encode()
+			assert.Nil(t, err)
+			assert.Equal(t, test.output, string(value))
+		})
+	}
+	assert.NotNil(t, service.SetMessageEncoding("hex"))
+}
+
+func TestLogInfoEncode(t *testing.T) {
+	timestamp := time.Date(2020, 10, 10, 8, 30, 0, 0, time.UTC)
+
+	testCases := []struct {
+		name      string
+		input     string
+		key       sarama.Encoder
+		headers   []sarama.RecordHeader
+		partition int32
+		timestamp time.Time
+		null      bool
+		err       bool
+	}{
+		{
+			name:      "Message only",
+			input:     `{"topic":"testdata","message":"x"}`,
+			partition: -1,
+		},
+		{
+			name:      "Key, partition and timestamp",
+			input:     `{"topic":"testdata","message":"x","key":"order-1","partition":2,"timestamp":"2020-10-10T08:30:00Z"}`,
+			key:       sarama.StringEncoder("order-1"),
+			partition: 2,
+			timestamp: timestamp,
+		},
+		{
+			name:      "Empty key and millisecond timestamp",
+			input:     `{"topic":"testdata","message":"x","key":"","timestamp":1602318600000}`,
+			key:       sarama.StringEncoder(""),
+			partition: -1,
+			timestamp: timestamp,
+		},
+		{
+			name:  "Headers object",
+			input: `{"topic":"testdata","message":"x","headers":{"b":"2","a":1}}`,
+			headers: []sarama.RecordHeader{
+				{Key: []byte("b"), Value: []byte("2")},
+				{Key: []byte("a"), Value: []byte("1")},
+			},
+			partition: -1,
+		},
+		{
+			name:  "Headers list",
+			input: `{"topic":"testdata","message":"x","headers":[{"key":"a","value":"1"},{"key":"a","value":"2"}]}`,
+			headers: []sarama.RecordHeader{
+				{Key: []byte("a"), Value: []byte("1")},
+				{Key: []byte("a"), Value: []byte("2")},
+			},
+			partition: -1,
+		},
+		{
+			name:      "Tombstone",
+			input:     `{"topic":"testdata","key":"order-1","null":true}`,
+			key:       sarama.StringEncoder("order-1"),
+			partition: -1,
+			null:      true,
+		},
+		{
+			name:  "Negative partition",
+			input: `{"topic":"testdata","message":"x","partition":-2}`,
+			err:   true,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			var logInfo services.LogInfo
+			assert.Nil(t, json.Unmarshal([]byte(test.input), &logInfo))
+			msg, err := logInfo.Encode(logInfo.Topic)
+			if test.err {
+				assert.NotNil(t, err)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, test.key, msg.Key)
+			assert.Equal(t, test.headers, msg.Headers)
+			assert.Equal(t, test.partition, msg.Partition)
+			assert.True(t, test.timestamp.Equal(msg.Timestamp))
+			assert.Equal(t, test.null, msg.Value == nil)
+		})
+	}
+}
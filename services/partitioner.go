@@ -0,0 +1,154 @@
+package services
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash"
+
+	"github.com/Shopify/sarama"
+)
+
+// repushPartitioner send message to the partition given by its log line, other messages are
+// partitioned by the chosen partitioner, by default keys are hashed like the java client does so
+// replayed messages land on their original partition
+type repushPartitioner struct {
+	fallback    sarama.Partitioner
+	checkpoints sarama.Partitioner
+}
+
+// checkpointRecord is the metadata of checkpoint records, their key is always hashed with murmur2 so
+// every checkpoint of an input file land on the same partition of the compacted topic
+type checkpointRecord struct{}
+
+//NewRepushPartitioner create partitioner honouring partition and key of log lines
+func NewRepushPartitioner(topic string) sarama.Partitioner {
+	return newRepushPartitioner(newMurmur2Partitioner)(topic)
+}
+
+//newMurmur2Partitioner create partitioner hashing keys like the java client does
+func newMurmur2Partitioner(topic string) sarama.Partitioner {
+	return sarama.NewCustomPartitioner(sarama.WithAbsFirst(), sarama.WithCustomHashFunction(newMurmur2))(topic)
+}
+
+//newRepushPartitioner create partitioners honouring partition of log lines, given partitioner is used otherwise
+func newRepushPartitioner(fallback sarama.PartitionerConstructor) sarama.PartitionerConstructor {
+	return func(topic string) sarama.Partitioner {
+		return &repushPartitioner{fallback: fallback(topic), checkpoints: newMurmur2Partitioner(topic)}
+	}
+}
+
+//partitionerConstructor get partitioner of given partitioner name, murmur2 key hash when it is not set
+func partitionerConstructor(name string) (sarama.PartitionerConstructor, error) {
+	switch name {
+	case "", "murmur2":
+		return NewRepushPartitioner, nil
+	case "fnv":
+		return newRepushPartitioner(sarama.NewHashPartitioner), nil
+	case "random":
+		return newRepushPartitioner(sarama.NewRandomPartitioner), nil
+	case "roundrobin":
+		return newRepushPartitioner(sarama.NewRoundRobinPartitioner), nil
+	default:
+		return nil, fmt.Errorf("producer.partitioner must be murmur2, fnv, random or roundrobin, got %q", name)
+	}
+}
+
+//Partition use partition of message when it is set, fallback partitioner otherwise
+func (p *repushPartitioner) Partition(message *sarama.ProducerMessage, numPartitions int32) (int32, error) {
+	if message.Partition >= 0 {
+		if message.Partition >= numPartitions {
+			return -1, sarama.ErrInvalidPartition
+		}
+		return message.Partition, nil
+	}
+	if _, ok := message.Metadata.(checkpointRecord); ok {
+		return p.checkpoints.Partition(message, numPartitions)
+	}
+	return p.fallback.Partition(message, numPartitions)
+}
+
+//RequiresConsistency ..
+func (p *repushPartitioner) RequiresConsistency() bool {
+	return true
+}
+
+//MessageRequiresConsistency let messages without partition move to another partition unless their key is hashed
+func (p *repushPartitioner) MessageRequiresConsistency(message *sarama.ProducerMessage) bool {
+	if message.Partition >= 0 {
+		return true
+	}
+	if _, ok := message.Metadata.(checkpointRecord); ok {
+		return true
+	}
+	if fallback, ok := p.fallback.(sarama.DynamicConsistencyPartitioner); ok {
+		return fallback.MessageRequiresConsistency(message)
+	}
+	return p.fallback.RequiresConsistency()
+}
+
+// murmur2 is the hash used by the java client default partitioner
+type murmur2 struct {
+	data []byte
+}
+
+func newMurmur2() hash.Hash32 {
+	return &murmur2{}
+}
+
+func (m *murmur2) Write(p []byte) (int, error) {
+	m.data = append(m.data, p...)
+	return len(p), nil
+}
+
+func (m *murmur2) Sum(b []byte) []byte {
+	var sum [4]byte
+	binary.BigEndian.PutUint32(sum[:], m.Sum32())
+	return append(b, sum[:]...)
+}
+
+func (m *murmur2) Reset() {
+	m.data = m.data[:0]
+}
+
+func (m *murmur2) Size() int {
+	return 4
+}
+
+func (m *murmur2) BlockSize() int {
+	return 4
+}
+
+func (m *murmur2) Sum32() uint32 {
+	const (
+		seed uint32 = 0x9747b28c
+		mix  uint32 = 0x5bd1e995
+		r           = 24
+	)
+	data := m.data
+	length := len(data)
+	h := seed ^ uint32(length)
+	for i := 0; i+4 <= length; i += 4 {
+		k := binary.LittleEndian.Uint32(data[i:])
+		k *= mix
+		k ^= k >> r
+		k *= mix
+		h *= mix
+		h ^= k
+	}
+	tail := data[length&^3:]
+	switch len(tail) {
+	case 3:
+		h ^= uint32(tail[2]) << 16
+		fallthrough
+	case 2:
+		h ^= uint32(tail[1]) << 8
+		fallthrough
+	case 1:
+		h ^= uint32(tail[0])
+		h *= mix
+	}
+	h ^= h >> 13
+	h *= mix
+	h ^= h >> 15
+	return h
+}
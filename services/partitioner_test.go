@@ -0,0 +1,54 @@
+package services_test
+
+import (
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/assert"
+	"kafka-repush/services"
+	"testing"
+)
+
+func TestRepushPartitioner(t *testing.T) {
+	partitioner := services.NewRepushPartitioner("testdata")
+
+	testCases := []struct {
+		name   string
+		input  *sarama.ProducerMessage
+		output int32
+		err    error
+	}{
+		{
+			name:   "Partition from log line",
+			input:  &sarama.ProducerMessage{Partition: 3},
+			output: 3,
+		},
+		{
+			name:   "Partition out of range",
+			input:  &sarama.ProducerMessage{Partition: 12},
+			output: -1,
+			err:    sarama.ErrInvalidPartition,
+		},
+		{
+			// Java client: Utils.toPositive(Utils.murmur2("21".getBytes())) % 10
+			name:   "Key hashed like java client",
+			input:  &sarama.ProducerMessage{Partition: -1, Key: sarama.StringEncoder("21")},
+			output: int32((-973932308 & 0x7fffffff) % 10),
+		},
+		{
+			name:   "Long key hashed like java client",
+			input:  &sarama.ProducerMessage{Partition: -1, Key: sarama.StringEncoder("a-little-bit-long-string")},
+			output: int32((-985981536 & 0x7fffffff) % 10),
+		},
+		{
+			name:   "Short key hashed like java client",
+			input:  &sarama.ProducerMessage{Partition: -1, Key: sarama.StringEncoder("abc")},
+			output: int32((479470107 & 0x7fffffff) % 10),
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			partition, err := partitioner.Partition(test.input, 10)
+			assert.Equal(t, test.err, err)
+			assert.Equal(t, test.output, partition)
+		})
+	}
+}
@@ -0,0 +1,380 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+type (
+	// ProducerConfig is the producer section of service configuration
+	ProducerConfig struct {
+		ClientID         string             `json:"clientId,omitempty"`
+		Version          string             `json:"version,omitempty"`
+		Acks             Acks               `json:"acks,omitempty"`
+		Idempotent       bool               `json:"idempotent,omitempty"`
+		Async            bool               `json:"async,omitempty"`
+		BatchSize        int                `json:"batchSize,omitempty"`
+		Linger           Duration           `json:"linger,omitempty"`
+		MaxInFlight      int                `json:"maxInFlight,omitempty"`
+		Compression      string             `json:"compression,omitempty"`
+		CompressionLevel *int               `json:"compressionLevel,omitempty"`
+		MaxMessageBytes  int                `json:"maxMessageBytes,omitempty"`
+		Retries          *int               `json:"retries,omitempty"`
+		RetryBackoff     Duration           `json:"retryBackoff,omitempty"`
+		Timeout          Duration           `json:"timeout,omitempty"`
+		DialTimeout      Duration           `json:"dialTimeout,omitempty"`
+		ReadTimeout      Duration           `json:"readTimeout,omitempty"`
+		WriteTimeout     Duration           `json:"writeTimeout,omitempty"`
+		Partitioner      string             `json:"partitioner,omitempty"`
+		MetadataRefresh  Duration           `json:"metadataRefresh,omitempty"`
+		TLS              *TLSConfig         `json:"tls,omitempty"`
+		SASL             *SASLConfig        `json:"sasl,omitempty"`
+		Transaction      *TransactionConfig `json:"transaction,omitempty"`
+	}
+
+	// Acks is the acknowledgement waited for, "all", "leader" or "none", or the numbers -1, 1 and 0
+	Acks string
+
+	// Duration is a time.Duration written as a string such as "100ms" in configuration
+	Duration time.Duration
+)
+
+//MarshalJSON ..
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+//UnmarshalJSON ..
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		return fmt.Errorf("duration must be a string such as \"100ms\": %s", data)
+	}
+	duration, err := time.ParseDuration(text)
+	if err != nil {
+		return err
+	}
+	*d = Duration(duration)
+	return nil
+}
+
+//UnmarshalJSON accept acks written as a string or a number
+func (a *Acks) UnmarshalJSON(data []byte) error {
+	var number int
+	if err := json.Unmarshal(data, &number); err == nil {
+		*a = Acks(strconv.Itoa(number))
+		return nil
+	}
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		return fmt.Errorf("acks must be \"all\", \"leader\", \"none\" or a number: %s", data)
+	}
+	*a = Acks(text)
+	return nil
+}
+
+//requiredAcks get sarama acks of given acks, all replicas when it is not set
+func (a Acks) requiredAcks() (sarama.RequiredAcks, error) {
+	switch a {
+	case "", "all", "-1":
+		return sarama.WaitForAll, nil
+	case "leader", "1":
+		return sarama.WaitForLocal, nil
+	case "none", "0":
+		return sarama.NoResponse, nil
+	default:
+		return sarama.WaitForAll, fmt.Errorf("producer.acks must be \"all\", \"leader\" or \"none\", got %q", string(a))
+	}
+}
+
+//NewProducerFromConfig create kafka producer with given brokers and producer configuration,
+//an asynchronous producer is created when configuration ask for it
+func NewProducerFromConfig(brokers []string, conf ProducerConfig) (Producer, error) {
+	config, err := conf.SaramaConfig()
+	if err != nil {
+		return nil, err
+	}
+	if conf.Transaction != nil {
+		producer, err := sarama.NewSyncProducer(brokers, config)
+		if err != nil {
+			return nil, producerError(err)
+		}
+		return NewTransactionalKafkaProducer(producer, *conf.Transaction), nil
+	}
+	if conf.Async {
+		producer, err := sarama.NewAsyncProducer(brokers, config)
+		if err != nil {
+			return nil, producerError(err)
+		}
+		return NewAsyncKafkaProducer(producer), nil
+	}
+	producer, err := sarama.NewSyncProducer(brokers, config)
+	if err != nil {
+		return nil, producerError(err)
+	}
+	return &KafkaProducer{Prod: producer}, nil
+}
+
+//Validate check producer configuration, errors name the offending settings
+func (c ProducerConfig) Validate() error {
+	_, err := c.SaramaConfig()
+	return err
+}
+
+//MessageBytesLimit get max size of a message producer may send, sarama default when it is not set
+func (c ProducerConfig) MessageBytesLimit() int {
+	if c.MaxMessageBytes > 0 {
+		return c.MaxMessageBytes
+	}
+	return sarama.NewConfig().Producer.MaxMessageBytes
+}
+
+//LineSizeLimit get default size limit of input lines, well above max message bytes so only lines
+//which could never fit in a message are refused while reading
+func (c ProducerConfig) LineSizeLimit() int {
+	if limit := 8 * c.MessageBytesLimit(); limit > DefaultMaxLineSize {
+		return limit
+	}
+	return DefaultMaxLineSize
+}
+
+//SaramaConfig map producer configuration onto sarama configuration, settings which are not set keep
+//sarama defaults
+func (c ProducerConfig) SaramaConfig() (*sarama.Config, error) {
+	if err := c.check(); err != nil {
+		return nil, err
+	}
+
+	config := sarama.NewConfig()
+	config.Producer.Return.Successes = true
+	config.Producer.Return.Errors = true
+
+	if c.ClientID != "" {
+		config.ClientID = c.ClientID
+	}
+	if c.Version != "" {
+		version, err := sarama.ParseKafkaVersion(c.Version)
+		if err != nil {
+			return nil, fmt.Errorf("producer.version: %v", err)
+		}
+		config.Version = version
+	}
+
+	acks, err := c.Acks.requiredAcks()
+	if err != nil {
+		return nil, err
+	}
+	config.Producer.RequiredAcks = acks
+
+	if c.Idempotent || c.Transaction != nil {
+		config.Producer.Idempotent = true
+		// Ordering is only kept with a single request in flight
+		config.Net.MaxOpenRequests = 1
+	}
+	if c.Transaction != nil {
+		config.Producer.Transaction.ID = c.Transaction.ID
+		if c.Transaction.Timeout > 0 {
+			config.Producer.Transaction.Timeout = time.Duration(c.Transaction.Timeout)
+		}
+		config.Consumer.IsolationLevel = sarama.ReadCommitted
+	}
+	if c.MaxInFlight > 0 {
+		config.Net.MaxOpenRequests = c.MaxInFlight
+	}
+
+	partitioner, err := partitionerConstructor(c.Partitioner)
+	if err != nil {
+		return nil, err
+	}
+	config.Producer.Partitioner = partitioner
+
+	if c.BatchSize > 0 {
+		config.Producer.Flush.Messages = c.BatchSize
+	}
+	if c.Linger > 0 {
+		config.Producer.Flush.Frequency = time.Duration(c.Linger)
+	}
+
+	codec, err := compressionCodec(c.Compression)
+	if err != nil {
+		return nil, err
+	}
+	config.Producer.Compression = codec
+	if c.CompressionLevel != nil {
+		config.Producer.CompressionLevel = *c.CompressionLevel
+	}
+	// zstd needs a recent broker, ask for it unless a version is given
+	if codec == sarama.CompressionZSTD && c.Version == "" {
+		config.Version = sarama.V2_1_0_0
+	}
+
+	if c.MaxMessageBytes > 0 {
+		config.Producer.MaxMessageBytes = c.MaxMessageBytes
+	}
+	if c.Retries != nil {
+		config.Producer.Retry.Max = *c.Retries
+	}
+	if c.RetryBackoff > 0 {
+		config.Producer.Retry.Backoff = time.Duration(c.RetryBackoff)
+	}
+	if c.Timeout > 0 {
+		config.Producer.Timeout = time.Duration(c.Timeout)
+	}
+	if c.DialTimeout > 0 {
+		config.Net.DialTimeout = time.Duration(c.DialTimeout)
+	}
+	if c.ReadTimeout > 0 {
+		config.Net.ReadTimeout = time.Duration(c.ReadTimeout)
+	}
+	if c.WriteTimeout > 0 {
+		config.Net.WriteTimeout = time.Duration(c.WriteTimeout)
+	}
+	if c.MetadataRefresh > 0 {
+		config.Metadata.RefreshFrequency = time.Duration(c.MetadataRefresh)
+	}
+
+	if c.TLS != nil {
+		if err := c.TLS.apply(config); err != nil {
+			return nil, err
+		}
+	}
+	if c.SASL != nil {
+		if err := c.SASL.apply(config); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := config.Validate(); err != nil {
+		return nil, fmt.Errorf("producer: %v", err)
+	}
+	return config, nil
+}
+
+//check report settings which are invalid alone or together
+func (c ProducerConfig) check() error {
+	for name, value := range map[string]int{
+		"batchSize":       c.BatchSize,
+		"maxInFlight":     c.MaxInFlight,
+		"maxMessageBytes": c.MaxMessageBytes,
+	} {
+		if value < 0 {
+			return fmt.Errorf("producer.%s must not be negative, got %d", name, value)
+		}
+	}
+	for name, value := range map[string]Duration{
+		"linger":          c.Linger,
+		"retryBackoff":    c.RetryBackoff,
+		"timeout":         c.Timeout,
+		"dialTimeout":     c.DialTimeout,
+		"readTimeout":     c.ReadTimeout,
+		"writeTimeout":    c.WriteTimeout,
+		"metadataRefresh": c.MetadataRefresh,
+	} {
+		if value < 0 {
+			return fmt.Errorf("producer.%s must not be negative, got %s", name, time.Duration(value))
+		}
+	}
+	if c.Retries != nil && *c.Retries < 0 {
+		return fmt.Errorf("producer.retries must not be negative, got %d", *c.Retries)
+	}
+
+	// A synchronous producer waits for every message, batching would only delay it
+	if !c.Async && (c.BatchSize > 0 || c.Linger > 0) {
+		return fmt.Errorf("producer.batchSize and producer.linger need producer.async")
+	}
+
+	if c.CompressionLevel != nil {
+		levels, ok := compressionLevels[c.Compression]
+		if !ok {
+			return fmt.Errorf("producer.compressionLevel is only supported for gzip and zstd compression, got compression %q", c.Compression)
+		}
+		if *c.CompressionLevel < levels[0] || *c.CompressionLevel > levels[1] {
+			return fmt.Errorf("producer.compressionLevel must be between %d and %d for %s, got %d",
+				levels[0], levels[1], c.Compression, *c.CompressionLevel)
+		}
+	}
+
+	if c.Version != "" {
+		version, err := sarama.ParseKafkaVersion(c.Version)
+		if err != nil {
+			return fmt.Errorf("producer.version: %v", err)
+		}
+		if c.Compression == "zstd" && !version.IsAtLeast(sarama.V2_1_0_0) {
+			return fmt.Errorf("producer.compression zstd needs producer.version 2.1.0 or later, got %s", c.Version)
+		}
+	}
+
+	if c.Transaction != nil {
+		if c.Transaction.ID == "" {
+			return fmt.Errorf("producer.transaction.id must be set")
+		}
+		if c.Transaction.CheckpointTopic == "" {
+			return fmt.Errorf("producer.transaction.checkpointTopic must be set")
+		}
+		if c.Transaction.Lines < 0 {
+			return fmt.Errorf("producer.transaction.lines must not be negative, got %d", c.Transaction.Lines)
+		}
+		if c.Transaction.Timeout < 0 {
+			return fmt.Errorf("producer.transaction.timeout must not be negative, got %s", time.Duration(c.Transaction.Timeout))
+		}
+		if c.Async {
+			return fmt.Errorf("producer.transaction can not be used with producer.async")
+		}
+	}
+
+	// Transactional producer is an idempotent producer
+	idempotent := ""
+	switch {
+	case c.Transaction != nil:
+		idempotent = "producer.transaction"
+	case c.Idempotent:
+		idempotent = "producer.idempotent"
+	default:
+		return nil
+	}
+	if c.Version != "" {
+		version, _ := sarama.ParseKafkaVersion(c.Version)
+		if !version.IsAtLeast(sarama.V0_11_0_0) {
+			return fmt.Errorf("%s needs producer.version 0.11.0 or later, got %s", idempotent, c.Version)
+		}
+	}
+	if acks, err := c.Acks.requiredAcks(); err == nil && acks != sarama.WaitForAll {
+		return fmt.Errorf("%s needs producer.acks \"all\", got %q", idempotent, string(c.Acks))
+	}
+	if c.MaxInFlight > 1 {
+		return fmt.Errorf("%s needs producer.maxInFlight 1, got %d", idempotent, c.MaxInFlight)
+	}
+	if c.Retries != nil && *c.Retries == 0 {
+		return fmt.Errorf("%s needs producer.retries above 0", idempotent)
+	}
+	return nil
+}
+
+// compressionLevels is the lowest and highest compression level of codecs supporting one, sarama ignore
+// the level of lz4
+var compressionLevels = map[string][2]int{
+	"gzip": {1, 9},
+	"zstd": {1, 22},
+}
+
+//compressionCodec get sarama codec of given compression name
+func compressionCodec(name string) (sarama.CompressionCodec, error) {
+	switch name {
+	case "", "none":
+		return sarama.CompressionNone, nil
+	case "gzip":
+		return sarama.CompressionGZIP, nil
+	case "snappy":
+		return sarama.CompressionSnappy, nil
+	case "lz4":
+		return sarama.CompressionLZ4, nil
+	case "zstd":
+		return sarama.CompressionZSTD, nil
+	default:
+		return sarama.CompressionNone, fmt.Errorf("producer.compression must be none, gzip, snappy, lz4 or zstd, got %q", name)
+	}
+}
@@ -0,0 +1,183 @@
+package services_test
+
+import (
+	"encoding/json"
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/assert"
+	"kafka-repush/services"
+	"log"
+	"testing"
+	"time"
+)
+
+func TestProducerConfigSaramaConfig(t *testing.T) {
+	var conf services.ProducerConfig
+	err := json.Unmarshal([]byte(`{
+		"clientId": "repush",
+		"version": "2.6.0",
+		"acks": 1,
+		"async": true,
+		"batchSize": 500,
+		"linger": "50ms",
+		"compression": "gzip",
+		"compressionLevel": 6,
+		"maxMessageBytes": 2000000,
+		"retries": 10,
+		"retryBackoff": "250ms",
+		"timeout": "5s",
+		"dialTimeout": "3s",
+		"metadataRefresh": "1m",
+		"partitioner": "roundrobin"
+	}`), &conf)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	config, err := conf.SaramaConfig()
+	assert.Nil(t, err)
+	assert.Equal(t, "repush", config.ClientID)
+	assert.Equal(t, sarama.V2_6_0_0, config.Version)
+	assert.Equal(t, sarama.WaitForLocal, config.Producer.RequiredAcks)
+	assert.Equal(t, 500, config.Producer.Flush.Messages)
+	assert.Equal(t, 50*time.Millisecond, config.Producer.Flush.Frequency)
+	assert.Equal(t, sarama.CompressionGZIP, config.Producer.Compression)
+	assert.Equal(t, 6, config.Producer.CompressionLevel)
+	assert.Equal(t, 2000000, config.Producer.MaxMessageBytes)
+	assert.Equal(t, 10, config.Producer.Retry.Max)
+	assert.Equal(t, 250*time.Millisecond, config.Producer.Retry.Backoff)
+	assert.Equal(t, 5*time.Second, config.Producer.Timeout)
+	assert.Equal(t, 3*time.Second, config.Net.DialTimeout)
+	assert.Equal(t, time.Minute, config.Metadata.RefreshFrequency)
+
+	// Explicit partition of log line is kept whatever the partitioner
+	partitioner := config.Producer.Partitioner("test")
+	partition, err := partitioner.Partition(&sarama.ProducerMessage{Partition: 2}, 4)
+	assert.Nil(t, err)
+	assert.Equal(t, int32(2), partition)
+}
+
+func TestProducerConfigDefaults(t *testing.T) {
+	config, err := services.ProducerConfig{}.SaramaConfig()
+	assert.Nil(t, err)
+	assert.Equal(t, sarama.WaitForAll, config.Producer.RequiredAcks)
+	assert.True(t, config.Producer.Return.Successes)
+
+	config, err = services.ProducerConfig{Idempotent: true}.SaramaConfig()
+	assert.Nil(t, err)
+	assert.True(t, config.Producer.Idempotent)
+	assert.Equal(t, 1, config.Net.MaxOpenRequests)
+
+	config, err = services.ProducerConfig{Compression: "zstd"}.SaramaConfig()
+	assert.Nil(t, err)
+	assert.True(t, config.Version.IsAtLeast(sarama.V2_1_0_0))
+}
+
+func TestProducerConfigLimits(t *testing.T) {
+	assert.Equal(t, 1000000, services.ProducerConfig{}.MessageBytesLimit())
+	assert.Equal(t, services.DefaultMaxLineSize, services.ProducerConfig{}.LineSizeLimit())
+
+	conf := services.ProducerConfig{MaxMessageBytes: 16 << 20}
+	assert.Equal(t, 16<<20, conf.MessageBytesLimit())
+	assert.Equal(t, 128<<20, conf.LineSizeLimit())
+}
+
+func TestProducerConfigValidate(t *testing.T) {
+	zero, level, zstdLevel := 0, 6, 19
+
+	testCases := []struct {
+		name   string
+		conf   services.ProducerConfig
+		expErr string
+	}{
+		{
+			name:   "Unknown acks",
+			conf:   services.ProducerConfig{Acks: "some"},
+			expErr: `producer.acks must be "all", "leader" or "none", got "some"`,
+		},
+		{
+			name:   "Invalid version",
+			conf:   services.ProducerConfig{Version: "two"},
+			expErr: "producer.version: invalid version `two`",
+		},
+		{
+			name:   "Idempotent without all acks",
+			conf:   services.ProducerConfig{Idempotent: true, Acks: "leader"},
+			expErr: `producer.idempotent needs producer.acks "all", got "leader"`,
+		},
+		{
+			name:   "Idempotent with many requests in flight",
+			conf:   services.ProducerConfig{Idempotent: true, Async: true, MaxInFlight: 5},
+			expErr: "producer.idempotent needs producer.maxInFlight 1, got 5",
+		},
+		{
+			name:   "Idempotent without retries",
+			conf:   services.ProducerConfig{Idempotent: true, Retries: &zero},
+			expErr: "producer.idempotent needs producer.retries above 0",
+		},
+		{
+			name:   "Idempotent with old version",
+			conf:   services.ProducerConfig{Idempotent: true, Version: "0.10.2.0"},
+			expErr: "producer.idempotent needs producer.version 0.11.0 or later, got 0.10.2.0",
+		},
+		{
+			name:   "Zstd with old version",
+			conf:   services.ProducerConfig{Compression: "zstd", Version: "2.0.0"},
+			expErr: "producer.compression zstd needs producer.version 2.1.0 or later, got 2.0.0",
+		},
+		{
+			name:   "Unknown compression",
+			conf:   services.ProducerConfig{Compression: "brotli"},
+			expErr: `producer.compression must be none, gzip, snappy, lz4 or zstd, got "brotli"`,
+		},
+		{
+			name:   "Compression level of snappy",
+			conf:   services.ProducerConfig{Compression: "snappy", CompressionLevel: &level},
+			expErr: `producer.compressionLevel is only supported for gzip and zstd compression, got compression "snappy"`,
+		},
+		{
+			name: "Compression level of zstd",
+			conf: services.ProducerConfig{Compression: "zstd", CompressionLevel: &zstdLevel},
+		},
+		{
+			name:   "Compression level out of gzip range",
+			conf:   services.ProducerConfig{Compression: "gzip", CompressionLevel: &zstdLevel},
+			expErr: "producer.compressionLevel must be between 1 and 9 for gzip, got 19",
+		},
+		{
+			name:   "Compression level of lz4",
+			conf:   services.ProducerConfig{Compression: "lz4", CompressionLevel: &level},
+			expErr: `producer.compressionLevel is only supported for gzip and zstd compression, got compression "lz4"`,
+		},
+		{
+			name:   "Batching without async",
+			conf:   services.ProducerConfig{BatchSize: 100},
+			expErr: "producer.batchSize and producer.linger need producer.async",
+		},
+		{
+			name:   "Negative timeout",
+			conf:   services.ProducerConfig{Timeout: services.Duration(-time.Second)},
+			expErr: "producer.timeout must not be negative, got -1s",
+		},
+		{
+			name:   "Unknown partitioner",
+			conf:   services.ProducerConfig{Partitioner: "sticky"},
+			expErr: `producer.partitioner must be murmur2, fnv, random or roundrobin, got "sticky"`,
+		},
+		{
+			name: "Valid",
+			conf: services.ProducerConfig{Idempotent: true, Acks: "-1", Version: "2.1.0", Compression: "zstd"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.conf.Validate()
+			if tc.expErr == "" {
+				assert.Nil(t, err)
+				return
+			}
+			if assert.NotNil(t, err) {
+				assert.Equal(t, tc.expErr, err.Error())
+			}
+		})
+	}
+}
@@ -0,0 +1,321 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+)
+
+// DefaultMaxLineSize is the least default size limit of a line. It only guard memory, a line encoding its
+// message in base64 or escaped JSON is larger than the message, which is checked against max message bytes
+const DefaultMaxLineSize = 64 << 20
+
+// LineTooLongError is the error of a line longer than max line size, the line is passed over
+type LineTooLongError struct {
+	Size    int64
+	MaxSize int
+}
+
+func (e *LineTooLongError) Error() string {
+	return fmt.Sprintf("line of %d bytes exceeds max line size %d", e.Size, e.MaxSize)
+}
+
+// LogReader read lines of an input file and keep track of its checkpoint,
+// rotated segments still holding unread lines are drained before the input file
+type LogReader struct {
+	name    string
+	seg     *segment
+	reader  *bufio.Reader
+	cp      Checkpoint
+	pos     Position
+	pending []string
+	maxSize int
+	// partial is the unterminated last line of input file, which is still being written
+	partial     []byte
+	partialSize int64
+}
+
+//OpenLogReader open input file and seek to the given checkpoint,
+//file is read from the beginning when checkpoint belong to another file or file has been truncated
+func OpenLogReader(name string, cp Checkpoint) (*LogReader, error) {
+	r := &LogReader{name: name}
+	if err := r.resume(cp); err != nil {
+		r.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+//resume move reader to the position of checkpoint
+func (r *LogReader) resume(cp Checkpoint) error {
+	live, err := openSegment(r.name)
+	if err != nil {
+		return err
+	}
+	if cp.File.IsZero() {
+		r.use(live)
+		// Checkpoint written before offsets were recorded, skip lines already pushed
+		for r.cp.Line < cp.Line {
+			if _, err := r.ReadLine(); err == io.EOF {
+				break
+			} else if err != nil {
+				return err
+			}
+		}
+		return r.identify()
+	}
+
+	if cp.Segment == "" {
+		resumable, err := live.holds(cp)
+		if err == nil && resumable && !live.compressed() {
+			resumable, err = cp.File.Matches(live.file)
+		}
+		if err != nil {
+			live.close()
+			return err
+		}
+		if resumable {
+			return r.start(live, cp)
+		}
+	}
+
+	path, err := findSegment(r.name, cp)
+	if err != nil {
+		live.close()
+		return err
+	}
+	if path == "" {
+		log.Printf("Checkpoint of %s not found, reading from the beginning \n", r.name)
+		r.use(live)
+		return r.identify()
+	}
+	live.close()
+	log.Printf("%s has been rotated, continue reading %s \n", r.name, path)
+	s, err := openSegment(path)
+	if err != nil {
+		return err
+	}
+	if r.pending, err = laterSegments(r.name, path); err != nil {
+		s.close()
+		return err
+	}
+	cp.Segment = path
+	return r.start(s, cp)
+}
+
+//start read given segment from checkpoint offset, compressed segment shorter than checkpoint offset
+//is read from the beginning like a file which shrank
+func (r *LogReader) start(s *segment, cp Checkpoint) error {
+	r.use(s)
+	err := s.seek(cp.Offset)
+	if s.compressed() && (err == io.EOF || err == io.ErrUnexpectedEOF) {
+		log.Printf("%s is shorter than its checkpoint, reading from the beginning \n", s.path)
+		s.close()
+		if s, err = openSegment(s.path); err != nil {
+			return err
+		}
+		r.use(s)
+		cp = Checkpoint{Segment: cp.Segment}
+	}
+	if err != nil {
+		return err
+	}
+	r.cp = cp
+	return r.identify()
+}
+
+//use read lines from given segment
+func (r *LogReader) use(s *segment) {
+	r.seg = s
+	r.reader = bufio.NewReader(s.src)
+}
+
+//identify record identity of the segment being read
+func (r *LogReader) identify() error {
+	identity, err := r.seg.identity()
+	if err != nil {
+		return err
+	}
+	r.cp.File = identity
+	return nil
+}
+
+//next continue with the next rotated segment or the input file once current segment is drained
+func (r *LogReader) next() error {
+	path := r.name
+	if len(r.pending) > 0 {
+		path = r.pending[0]
+	}
+	s, err := openSegment(path)
+	if err != nil {
+		return err
+	}
+	log.Printf("Finished reading %s, continue with %s \n", r.seg.path, path)
+	r.seg.close()
+	r.use(s)
+	if len(r.pending) > 0 {
+		r.pending = r.pending[1:]
+	}
+	r.cp = Checkpoint{}
+	if path != r.name {
+		r.cp.Segment = path
+	}
+	return r.identify()
+}
+
+//SetMaxLineSize limit size of lines without line ending, zero size is no limit
+func (r *LogReader) SetMaxLineSize(size int) {
+	r.maxSize = size
+}
+
+//ReadLine read next line without line ending, return io.EOF when there is nothing left to read.
+//Last line of input file without line ending is not read until it is complete, as writer may be
+//in the middle of it. A line longer than max line size is passed over with a LineTooLongError,
+//the returned line hold its head only and ReadLineAt read it whole from its position
+func (r *LogReader) ReadLine() ([]byte, error) {
+	for {
+		line, size, err := r.readLine()
+		if err == io.ErrUnexpectedEOF && r.seg.compressed() {
+			// Truncated archive end where it can no longer be read
+			log.Printf("%s is truncated at offset %d \n", r.seg.path, r.cp.Offset+size)
+			err = io.EOF
+		}
+		if err == io.EOF && size > 0 && r.appendable() {
+			r.partial, r.partialSize = line, size
+			return nil, io.EOF
+		}
+		if size > 0 && (err == nil || err == io.EOF) {
+			r.pos = Position{Source: r.seg.path, Offset: r.cp.Offset, Line: r.cp.Line + 1}
+			r.cp.Offset += size
+			r.cp.Line++
+			line = bytes.TrimSuffix(line, []byte("\n"))
+			line = bytes.TrimSuffix(line, []byte("\r"))
+			if r.maxSize > 0 && len(line) > r.maxSize {
+				if err == nil {
+					size--
+				}
+				return line[:r.maxSize], &LineTooLongError{Size: size, MaxSize: r.maxSize}
+			}
+			return line, nil
+		}
+		if err == io.EOF && r.cp.Segment != "" {
+			if err := r.next(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		return nil, err
+	}
+}
+
+//readLine read next line with its line ending, only the head of a line longer than max line size
+//is kept. Size is the number of bytes read
+func (r *LogReader) readLine() ([]byte, int64, error) {
+	line, size := r.partial, r.partialSize
+	r.partial, r.partialSize = nil, 0
+	for {
+		chunk, err := r.reader.ReadSlice('\n')
+		size += int64(len(chunk))
+		// Keep room for line ending to tell a line of exactly max line size
+		if r.maxSize > 0 && len(line)+len(chunk) > r.maxSize+2 {
+			chunk = chunk[:r.maxSize+2-len(line)]
+		}
+		line = append(line, chunk...)
+		if err != bufio.ErrBufferFull {
+			return line, size, err
+		}
+	}
+}
+
+//appendable report whether segment being read is input file which may still be written, rotated
+//segments and compressed files are complete
+func (r *LogReader) appendable() bool {
+	return r.cp.Segment == "" && !r.seg.compressed()
+}
+
+//ReadLineAt read line of given length without line ending at offset of file, offset of compressed file
+//is an uncompressed offset
+func ReadLineAt(name string, offset int64, length int64) ([]byte, error) {
+	s, err := openSegment(name)
+	if err != nil {
+		return nil, err
+	}
+	defer s.close()
+	if err := s.seek(offset); err != nil {
+		return nil, err
+	}
+	line := make([]byte, length)
+	if _, err := io.ReadFull(s.src, line); err != nil {
+		return nil, fmt.Errorf("read line at %s:%d: %v", name, offset, err)
+	}
+	return bytes.TrimSuffix(line, []byte("\r")), nil
+}
+
+//Position get position of the last read line
+func (r *LogReader) Position() Position {
+	return r.pos
+}
+
+//Rotated report whether input file has been replaced or truncated since it was opened,
+//a missing input file is not seen as rotated until it is created again
+func (r *LogReader) Rotated() (bool, error) {
+	if r.cp.Segment != "" {
+		return false, nil
+	}
+	info, err := os.Stat(r.name)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	current, err := r.seg.file.Stat()
+	if err != nil {
+		return false, err
+	}
+	if !os.SameFile(info, current) {
+		return true, nil
+	}
+	if r.seg.compressed() {
+		// Compressed file is never appended, offsets are uncompressed offsets
+		return false, nil
+	}
+	if info.Size() < r.cp.Offset {
+		return true, nil
+	}
+	matches, err := r.cp.File.MatchesContent(io.NewSectionReader(r.seg.file, 0, r.cp.File.FingerprintSize))
+	return !matches, err
+}
+
+//Missing report whether input file being read no longer exists under its name
+func (r *LogReader) Missing() (bool, error) {
+	if r.cp.Segment != "" {
+		return false, nil
+	}
+	_, err := os.Stat(r.name)
+	if os.IsNotExist(err) {
+		return true, nil
+	}
+	return false, err
+}
+
+//Checkpoint get checkpoint right after the last read line. File identity is the one taken when the file was
+//opened, its size grows with the offset read past it so the file is not looked at for every line
+func (r *LogReader) Checkpoint() Checkpoint {
+	cp := r.cp
+	if !r.seg.compressed() && cp.File.Size < cp.Offset {
+		cp.File.Size = cp.Offset
+	}
+	return cp
+}
+
+//Close close input file
+func (r *LogReader) Close() error {
+	if r.seg == nil {
+		return nil
+	}
+	return r.seg.close()
+}
@@ -0,0 +1,196 @@
+package services_test
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io"
+	"io/ioutil"
+	"kafka-repush/services"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func readLines(name string, cp services.Checkpoint) ([]string, services.Checkpoint) {
+	reader, err := services.OpenLogReader(name, cp)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer reader.Close()
+
+	var lines []string
+	for {
+		line, err := reader.ReadLine()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		lines = append(lines, string(line))
+	}
+	return lines, reader.Checkpoint()
+}
+
+func TestLogReader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "reader")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "log.txt")
+
+	writeFile := func(content string, flag int) {
+		file, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|flag, 0644)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer file.Close()
+		if _, err := file.WriteString(content); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	var cp services.Checkpoint
+	testCases := []struct {
+		name       string
+		setUp      func()
+		checkpoint func() services.Checkpoint
+		output     []string
+		offset     int64
+	}{
+		{
+			name: "Read new file",
+			setUp: func() {
+				writeFile("line 1\r\nline 2\n", os.O_TRUNC)
+			},
+			checkpoint: func() services.Checkpoint { return services.Checkpoint{} },
+			output:     []string{"line 1", "line 2"},
+			offset:     15,
+		},
+		{
+			name:       "Nothing new",
+			setUp:      func() {},
+			checkpoint: func() services.Checkpoint { return cp },
+			output:     nil,
+			offset:     15,
+		},
+		{
+			name: "Resume after append",
+			setUp: func() {
+				writeFile("line 3\n", os.O_APPEND)
+			},
+			checkpoint: func() services.Checkpoint { return cp },
+			output:     []string{"line 3"},
+			offset:     22,
+		},
+		{
+			name: "Resume legacy line checkpoint",
+			setUp: func() {
+				writeFile("line 4\n", os.O_APPEND)
+			},
+			checkpoint: func() services.Checkpoint { return services.Checkpoint{Line: 3} },
+			output:     []string{"line 4"},
+			offset:     29,
+		},
+		{
+			name: "Restart truncated file",
+			setUp: func() {
+				writeFile("new 1\n", os.O_TRUNC)
+			},
+			checkpoint: func() services.Checkpoint { return cp },
+			output:     []string{"new 1"},
+			offset:     6,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			test.setUp()
+			var lines []string
+			lines, cp = readLines(name, test.checkpoint())
+			assert.Equal(t, test.output, lines)
+			assert.Equal(t, test.offset, cp.Offset)
+		})
+	}
+}
+
+func TestLogReaderMaxLineSize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "reader")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "log.txt")
+
+	// Longer than bufio buffer so the line is read in chunks
+	long := strings.Repeat("x", 70000)
+	content := "short\n" + long + "\r\n" + "1234567890\r\n" + "12345678901\n" + "last\n"
+	if err := ioutil.WriteFile(name, []byte(content), 0644); err != nil {
+		log.Fatal(err)
+	}
+
+	reader, err := services.OpenLogReader(name, services.Checkpoint{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer reader.Close()
+	reader.SetMaxLineSize(10)
+
+	testCases := []struct {
+		expLine string
+		expErr  error
+	}{
+		{expLine: "short"},
+		{expLine: "xxxxxxxxxx", expErr: &services.LineTooLongError{Size: 70001, MaxSize: 10}},
+		{expLine: "1234567890"},
+		{expLine: "1234567890", expErr: &services.LineTooLongError{Size: 11, MaxSize: 10}},
+		{expLine: "last"},
+	}
+	for _, tc := range testCases {
+		line, err := reader.ReadLine()
+		assert.Equal(t, tc.expLine, string(line))
+		assert.Equal(t, tc.expErr, err)
+	}
+	_, err = reader.ReadLine()
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, int64(len(content)), reader.Checkpoint().Offset)
+	assert.Equal(t, int64(5), reader.Checkpoint().Line)
+}
+
+func TestLogReaderPartialLine(t *testing.T) {
+	dir, err := ioutil.TempDir("", "reader")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "log.txt")
+	appendFile(name, "{\"topic\":\"orders\",\"message\":\"1\"}\n{\"topic\":\"ord")
+
+	reader, err := services.OpenLogReader(name, services.Checkpoint{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer reader.Close()
+	line, err := reader.ReadLine()
+	assert.Nil(t, err)
+	assert.Equal(t, `{"topic":"orders","message":"1"}`, string(line))
+
+	// Line being written is neither read nor checkpointed
+	_, err = reader.ReadLine()
+	assert.Equal(t, io.EOF, err)
+	cp := reader.Checkpoint()
+	assert.Equal(t, int64(33), cp.Offset)
+	assert.Equal(t, int64(1), cp.Line)
+
+	// Line is read once it is complete, by the same reader or from checkpoint on next run
+	appendFile(name, "ers\",\"message\":\"2\"}\n")
+	line, err = reader.ReadLine()
+	assert.Nil(t, err)
+	assert.Equal(t, `{"topic":"orders","message":"2"}`, string(line))
+	assert.Equal(t, services.Position{Source: name, Offset: 33, Line: 2}, reader.Position())
+
+	lines, next := readLines(name, cp)
+	assert.Equal(t, []string{`{"topic":"orders","message":"2"}`}, lines)
+	assert.Equal(t, reader.Checkpoint().Offset, next.Offset)
+}
@@ -0,0 +1,125 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// RetryResult summarize a retry of error file
+type RetryResult struct {
+	Sent        int
+	Failed      int
+	Quarantined int
+}
+
+//RetryFailPush send entries of error file again, sent entries are removed from error file and
+//entries failing maxAttempts push attempts are moved to quarantine file, zero maxAttempts never quarantine.
+//Error file is read and replaced under its lock, records appended by other processes while entries are
+//pushed are kept
+func (h *LogHandler) RetryFailPush(errorName string, quarantineName string, maxAttempts int) (RetryResult, error) {
+	var result RetryResult
+	content, err := readFileLocked(errorName)
+	if os.IsNotExist(err) {
+		return result, nil
+	}
+	if err != nil {
+		return result, err
+	}
+
+	var remaining, quarantined []byte
+	reader := NewFailReader(bytes.NewReader(content))
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return result, err
+		}
+		// Failed lines are pushed whether they match filter or not, they were selected when first read
+		line, err := record.FullLine()
+		if err != nil {
+			err = &PushError{Class: ErrorClassSize, Err: err}
+		} else {
+			err = h.pushLine(line, nil)
+		}
+		if err == nil {
+			result.Sent++
+			continue
+		}
+		record.Attempts++
+		record.Time = time.Now().UTC()
+		record.setError(err)
+		recordByte, err := json.Marshal(record)
+		if err != nil {
+			return result, err
+		}
+		if maxAttempts > 0 && record.Attempts >= maxAttempts {
+			result.Quarantined++
+			quarantined = append(append(quarantined, recordByte...), '\n')
+			continue
+		}
+		result.Failed++
+		remaining = append(append(remaining, recordByte...), '\n')
+	}
+
+	// Quarantine first, a crash in between may duplicate entries but never lose them
+	if len(quarantined) > 0 {
+		if err := appendFile(quarantineName, quarantined); err != nil {
+			return result, err
+		}
+	}
+	return result, replaceRetried(errorName, content, remaining)
+}
+
+//readFileLocked read file under its lock
+func readFileLocked(name string) ([]byte, error) {
+	unlock, err := lockName(name)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	return ioutil.ReadFile(name)
+}
+
+//replaceRetried replace retried content of error file with remaining entries under its lock, records appended
+//since it was read are kept after them. Error file rewritten by another retry is left as is, its entries may
+//then be pushed twice but are never lost
+func replaceRetried(errorName string, retried []byte, remaining []byte) error {
+	unlock, err := lockName(errorName)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	current, err := ioutil.ReadFile(errorName)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if !bytes.HasPrefix(current, retried) {
+		return fmt.Errorf("error file %s was rewritten during retry", errorName)
+	}
+	return writeFileAtomic(errorName, append(remaining, current[len(retried):]...), 0644)
+}
+
+//appendFile append data to file and flush it to disk
+func appendFile(name string, data []byte) error {
+	file, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,197 @@
+package services_test
+
+import (
+	"errors"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"io/ioutil"
+	"kafka-repush/services"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type retryRecord struct {
+	line     string
+	class    string
+	attempts int
+}
+
+func readFailRecords(name string) []retryRecord {
+	file, err := os.Open(name)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer file.Close()
+
+	var records []retryRecord
+	reader := services.NewFailReader(file)
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return records
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		records = append(records, retryRecord{line: record.Line, class: record.Class, attempts: record.Attempts})
+	}
+}
+
+func TestRetryFailPush(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mockKafka := NewMockProducer(ctrl)
+	service := services.NewLogHandler(mockKafka)
+
+	dir, err := ioutil.TempDir("", "retry")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	errorName := filepath.Join(dir, "error.txt")
+	quarantineName := filepath.Join(dir, "quarantine.txt")
+
+	sent := services.LogInfo{Topic: "testdata", Message: "sent"}
+	failed := services.LogInfo{Topic: "testdata", Message: "failed"}
+	failErr := errors.New("sending message failed")
+	sentLine := `{"topic":"testdata","message":"sent"}`
+	failedLine := `{"topic":"testdata","message":"failed"}`
+	brokenLine := `{"topic":"testdata","message":`
+	appendFile(errorName, sentLine+"\n"+failedLine+"\n"+brokenLine+"\n")
+
+	testCases := []struct {
+		name       string
+		tearDown   func()
+		output     services.RetryResult
+		errorFile  []retryRecord
+		quarantine []retryRecord
+	}{
+		{
+			name: "Sent entry is removed",
+			tearDown: func() {
+				mockKafka.EXPECT().Send(sent.Topic, sent).Times(1).Return(nil)
+				mockKafka.EXPECT().Send(failed.Topic, failed).Times(1).Return(failErr)
+			},
+			output: services.RetryResult{Sent: 1, Failed: 2},
+			errorFile: []retryRecord{
+				{line: failedLine, class: services.ErrorClassBroker, attempts: 2},
+				{line: brokenLine, class: services.ErrorClassParse, attempts: 2},
+			},
+		},
+		{
+			name: "Entry failing too many times is quarantined",
+			tearDown: func() {
+				mockKafka.EXPECT().Send(failed.Topic, failed).Times(1).Return(failErr)
+			},
+			output:    services.RetryResult{Quarantined: 2},
+			errorFile: nil,
+			quarantine: []retryRecord{
+				{line: failedLine, class: services.ErrorClassBroker, attempts: 3},
+				{line: brokenLine, class: services.ErrorClassParse, attempts: 3},
+			},
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			test.tearDown()
+			result, err := service.RetryFailPush(errorName, quarantineName, 3)
+			assert.Nil(t, err)
+			assert.Equal(t, test.output, result)
+			assert.Equal(t, test.errorFile, readFailRecords(errorName))
+			assert.Equal(t, test.quarantine, readFailRecords(quarantineName))
+		})
+	}
+}
+
+func TestRetryLineTooLong(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mockKafka := NewMockProducer(ctrl)
+	service := services.NewLogHandler(mockKafka)
+
+	dir, err := ioutil.TempDir("", "retry")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	inputName := filepath.Join(dir, "log.txt")
+	errorName := filepath.Join(dir, "error.txt")
+	quarantineName := filepath.Join(dir, "quarantine.txt")
+
+	// Second line is recorded with its head only, as it is read with a max line size of 20
+	longLine := `{"topic":"testdata","message":"long enough"}`
+	appendFile(inputName, "{\"topic\":\"testdata\"}\n"+longLine+"\r\n")
+	reader, err := services.OpenLogReader(inputName, services.Checkpoint{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	reader.SetMaxLineSize(20)
+	_, err = reader.ReadLine()
+	assert.Nil(t, err)
+	head, err := reader.ReadLine()
+	assert.Equal(t, longLine[:20], string(head))
+	record := services.NewFailRecord(head, reader.Position(), err)
+	reader.Close()
+	assert.Equal(t, int64(len(longLine)+1), record.Length)
+
+	file, err := os.Create(errorName)
+	if err != nil {
+		log.Fatal(err)
+	}
+	assert.Nil(t, service.WriteFailRecord(file, record))
+	assert.Nil(t, service.WriteFailRecord(file, services.NewFailRecord([]byte(`{"topic":"changed"`),
+		services.Position{Source: inputName, Offset: 0}, &services.LineTooLongError{Size: 21, MaxSize: 18})))
+	file.Close()
+
+	mockKafka.EXPECT().Send("testdata", services.LogInfo{Topic: "testdata", Message: "long enough"}).Times(1).Return(nil)
+	result, err := service.RetryFailPush(errorName, quarantineName, 3)
+	assert.Nil(t, err)
+	assert.Equal(t, services.RetryResult{Sent: 1, Failed: 1}, result)
+	assert.Equal(t, []retryRecord{{line: `{"topic":"changed"`, class: services.ErrorClassSize, attempts: 2}}, readFailRecords(errorName))
+}
+
+func TestRetryKeepsAppendedRecords(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mockKafka := NewMockProducer(ctrl)
+	service := services.NewLogHandler(mockKafka)
+
+	dir, err := ioutil.TempDir("", "retry")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	errorName := filepath.Join(dir, "error.txt")
+	quarantineName := filepath.Join(dir, "quarantine.txt")
+
+	// Error file is kept open for appending like a running follow process does
+	errorFile, err := os.OpenFile(errorName, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer errorFile.Close()
+	failedLine := `{"topic":"testdata","message":"failed"}`
+	duringLine := `{"topic":"testdata","message":"during"}`
+	afterLine := `{"topic":"testdata","message":"after"}`
+	failErr := errors.New("sending message failed")
+	assert.Nil(t, service.WriteFailRecord(errorFile, services.NewFailRecord([]byte(failedLine), services.Position{}, failErr)))
+
+	mockKafka.EXPECT().Send("testdata", services.LogInfo{Topic: "testdata", Message: "failed"}).Times(1).
+		DoAndReturn(func(topic string, message interface{}) error {
+			assert.Nil(t, service.WriteFailRecord(errorFile, services.NewFailRecord([]byte(duringLine), services.Position{}, failErr)))
+			return failErr
+		})
+	result, err := service.RetryFailPush(errorName, quarantineName, 3)
+	assert.Nil(t, err)
+	assert.Equal(t, services.RetryResult{Failed: 1}, result)
+	assert.Nil(t, service.WriteFailRecord(errorFile, services.NewFailRecord([]byte(afterLine), services.Position{}, failErr)))
+
+	assert.Equal(t, []retryRecord{
+		{line: failedLine, class: services.ErrorClassBroker, attempts: 2},
+		{line: duringLine, class: services.ErrorClassUnknown, attempts: 1},
+		{line: afterLine, class: services.ErrorClassUnknown, attempts: 1},
+	}, readFailRecords(errorName))
+}
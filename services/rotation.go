@@ -0,0 +1,197 @@
+package services
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// rotationSuffix match what rotation tools append to a file name, a counter or a date
+var rotationSuffix = regexp.MustCompile(`^[._-]\d[\d._-]*$`)
+
+type (
+	// segment is the input file or one of its rotated segments opened for reading
+	segment struct {
+		path string
+		file *os.File
+		src  io.Reader
+	}
+
+	// segmentInfo is a rotated segment found next to the input file
+	segmentInfo struct {
+		path    string
+		modTime time.Time
+	}
+)
+
+//openSegment open file at path, compressed segment is read through its decompressor so offsets
+//are uncompressed offsets
+func openSegment(path string) (*segment, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	compression, err := DetectCompression(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	src, err := newDecompressor(file, compression)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &segment{path: path, file: file, src: src}, nil
+}
+
+//compressed report whether segment is read through a decompressor
+func (s *segment) compressed() bool {
+	return s.src != io.Reader(s.file)
+}
+
+//seek move segment to given uncompressed offset
+func (s *segment) seek(offset int64) error {
+	if !s.compressed() {
+		_, err := s.file.Seek(offset, io.SeekStart)
+		return err
+	}
+	_, err := io.CopyN(ioutil.Discard, s.src, offset)
+	return err
+}
+
+//identity get identity of segment, compressed segment is identified by its uncompressed head only
+func (s *segment) identity() (FileIdentity, error) {
+	if !s.compressed() {
+		return NewFileIdentity(s.file)
+	}
+	head, err := openSegment(s.path)
+	if err != nil {
+		return FileIdentity{}, err
+	}
+	defer head.close()
+	sum, size, err := fingerprint(head.src, fingerprintSize)
+	if err != nil {
+		return FileIdentity{}, err
+	}
+	return FileIdentity{Fingerprint: sum, FingerprintSize: size}, nil
+}
+
+//holds report whether segment is the file of checkpoint and still contains its offset
+func (s *segment) holds(cp Checkpoint) (bool, error) {
+	if s.compressed() {
+		// Position is checked while draining, the uncompressed size is unknown here
+		head, err := openSegment(s.path)
+		if err != nil {
+			return false, err
+		}
+		defer head.close()
+		return cp.File.MatchesContent(head.src)
+	}
+	info, err := s.file.Stat()
+	if err != nil {
+		return false, err
+	}
+	if info.Size() < cp.Offset {
+		return false, nil
+	}
+	return cp.File.MatchesContent(io.NewSectionReader(s.file, 0, cp.File.FingerprintSize))
+}
+
+//close close segment and its decompressor
+func (s *segment) close() error {
+	if closer, ok := s.src.(io.Closer); ok && s.compressed() {
+		if err := closer.Close(); err != nil {
+			s.file.Close()
+			return err
+		}
+	}
+	return s.file.Close()
+}
+
+//isRotatedName report whether path is named like a rotated segment of input file, which is the input file name
+//followed by a counter or a date and maybe a compression extension. Files inserting them before the extension,
+//such as pod-2.log or app-20201010.log next to app.log, are as likely inputs of their own and are not segments
+func isRotatedName(name string, path string) bool {
+	rest := strings.TrimSuffix(path, compressedExt(path))
+	return strings.HasPrefix(rest, name) && rotationSuffix.MatchString(strings.TrimPrefix(rest, name))
+}
+
+//rotatedSegments list rotated segments of input file from oldest to newest,
+//segments are files next to input named after it such as log.txt.1, log.txt-20201010.gz or log.txt.2.zst
+func rotatedSegments(name string) ([]segmentInfo, error) {
+	name = filepath.Clean(name)
+	paths, err := filepath.Glob(name + "?*")
+	if err != nil {
+		return nil, err
+	}
+
+	seen := map[string]bool{name: true}
+	var segments []segmentInfo
+	for _, path := range paths {
+		path = filepath.Clean(path)
+		if seen[path] || !isRotatedName(name, path) {
+			continue
+		}
+		seen[path] = true
+		info, err := os.Stat(path)
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		segments = append(segments, segmentInfo{path: path, modTime: info.ModTime()})
+	}
+	sort.SliceStable(segments, func(i, j int) bool {
+		return segments[i].modTime.Before(segments[j].modTime)
+	})
+	return segments, nil
+}
+
+//findSegment look for the rotated segment holding checkpoint, return empty path when there is none
+func findSegment(name string, cp Checkpoint) (string, error) {
+	segments, err := rotatedSegments(name)
+	if err != nil {
+		return "", err
+	}
+	paths := make([]string, 0, len(segments)+1)
+	if cp.Segment != "" {
+		paths = append(paths, cp.Segment)
+	}
+	for _, s := range segments {
+		paths = append(paths, s.path)
+	}
+	for _, path := range paths {
+		s, err := openSegment(path)
+		if err != nil {
+			continue
+		}
+		holds, err := s.holds(cp)
+		s.close()
+		if err == nil && holds {
+			return path, nil
+		}
+	}
+	return "", nil
+}
+
+//laterSegments list rotated segments written after the segment at path, oldest first
+func laterSegments(name string, path string) ([]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	segments, err := rotatedSegments(name)
+	if err != nil {
+		return nil, err
+	}
+	var later []string
+	for _, s := range segments {
+		if s.path != filepath.Clean(path) && s.modTime.After(info.ModTime()) {
+			later = append(later, s.path)
+		}
+	}
+	return later, nil
+}
@@ -0,0 +1,210 @@
+package services_test
+
+import (
+	"compress/gzip"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"kafka-repush/services"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func appendFile(name string, content string) {
+	file, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer file.Close()
+	if _, err := file.WriteString(content); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func gzipFile(name string) {
+	content, err := ioutil.ReadFile(name)
+	if err != nil {
+		log.Fatal(err)
+	}
+	file, err := os.Create(name + ".gz")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer file.Close()
+	gz := gzip.NewWriter(file)
+	if _, err := gz.Write(content); err != nil {
+		log.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		log.Fatal(err)
+	}
+	if err := os.Remove(name); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func setModTime(name string, age time.Duration) {
+	modTime := time.Now().Add(-age)
+	if err := os.Chtimes(name, modTime, modTime); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func TestLogReaderRotation(t *testing.T) {
+	testCases := []struct {
+		name   string
+		rotate func(name string)
+		output []string
+	}{
+		{
+			name: "Rename and recreate",
+			rotate: func(name string) {
+				if err := os.Rename(name, name+".1"); err != nil {
+					log.Fatal(err)
+				}
+				appendFile(name, "new 1\n")
+			},
+			output: []string{"line 3", "new 1"},
+		},
+		{
+			name: "Copy and truncate",
+			rotate: func(name string) {
+				content, err := ioutil.ReadFile(name)
+				if err != nil {
+					log.Fatal(err)
+				}
+				if err := ioutil.WriteFile(name+".1", content, 0644); err != nil {
+					log.Fatal(err)
+				}
+				if err := os.Truncate(name, 0); err != nil {
+					log.Fatal(err)
+				}
+				appendFile(name, "new 1\n")
+			},
+			output: []string{"line 3", "new 1"},
+		},
+		{
+			name: "Rename and compress",
+			rotate: func(name string) {
+				if err := os.Rename(name, name+".1"); err != nil {
+					log.Fatal(err)
+				}
+				gzipFile(name + ".1")
+				appendFile(name, "new 1\n")
+			},
+			output: []string{"line 3", "new 1"},
+		},
+		{
+			name: "Rotated twice",
+			rotate: func(name string) {
+				if err := os.Rename(name, name+".2"); err != nil {
+					log.Fatal(err)
+				}
+				setModTime(name+".2", 2*time.Hour)
+				appendFile(name+".1", "middle 1\n")
+				setModTime(name+".1", time.Hour)
+				appendFile(name, "new 1\n")
+			},
+			output: []string{"line 3", "middle 1", "new 1"},
+		},
+		{
+			name: "Unrelated files are ignored",
+			rotate: func(name string) {
+				if err := os.Rename(name, name+".1"); err != nil {
+					log.Fatal(err)
+				}
+				setModTime(name+".1", time.Hour)
+				appendFile(filepath.Join(filepath.Dir(name), "log-errors.txt"), "error 1\n")
+				appendFile(name, "new 1\n")
+			},
+			output: []string{"line 3", "new 1"},
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "rotation")
+			if err != nil {
+				log.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			name := filepath.Join(dir, "log.txt")
+
+			appendFile(name, "line 1\nline 2\n")
+			_, cp := readLines(name, services.Checkpoint{})
+			appendFile(name, "line 3\n")
+			test.rotate(name)
+
+			lines, cp := readLines(name, cp)
+			assert.Equal(t, test.output, lines)
+			assert.Equal(t, "", cp.Segment)
+			assert.Equal(t, int64(6), cp.Offset)
+		})
+	}
+}
+
+func TestLogReaderRotationBesideOtherInputs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rotation")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	dir, err = filepath.EvalSymlinks(dir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	name := filepath.Join(dir, "pod.log")
+	other := filepath.Join(dir, "pod-2.log")
+	dated := filepath.Join(dir, "pod-20201010.log")
+
+	appendFile(name, "a1\na2\n")
+	_, cp := readLines(name, services.Checkpoint{})
+	appendFile(name, "a3\n")
+	if err := os.Rename(name, name+".1"); err != nil {
+		log.Fatal(err)
+	}
+	setModTime(name+".1", time.Hour)
+	appendFile(other, "b1\nb2\n")
+	appendFile(dated, "c1\n")
+	appendFile(name, "a4\n")
+
+	// Files of other pods and days are inputs of their own, only pod.log.1 is drained before pod.log
+	lines, _ := readLines(name, cp)
+	assert.Equal(t, []string{"a3", "a4"}, lines)
+	inputs, err := services.ResolveInputs(dir, false)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{other, dated, name}, inputs)
+}
+
+func TestLogReaderRotationResume(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rotation")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "log.txt")
+
+	appendFile(name, "line 1\n")
+	_, cp := readLines(name, services.Checkpoint{})
+	appendFile(name, "line 2\nline 3\n")
+	if err := os.Rename(name, name+".1"); err != nil {
+		log.Fatal(err)
+	}
+	appendFile(name, "new 1\n")
+
+	reader, err := services.OpenLogReader(name, cp)
+	if err != nil {
+		log.Fatal(err)
+	}
+	line, err := reader.ReadLine()
+	assert.Nil(t, err)
+	assert.Equal(t, "line 2", string(line))
+	cp = reader.Checkpoint()
+	reader.Close()
+	assert.Equal(t, name+".1", cp.Segment)
+
+	lines, cp := readLines(name, cp)
+	assert.Equal(t, []string{"line 3", "new 1"}, lines)
+	assert.Equal(t, "", cp.Segment)
+}
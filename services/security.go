@@ -0,0 +1,213 @@
+package services
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/Shopify/sarama"
+	"github.com/xdg/scram"
+)
+
+type (
+	// TLSConfig is the TLS section of producer configuration, a client certificate enable mutual TLS
+	TLSConfig struct {
+		CAFile             string `json:"caFile,omitempty"`
+		CertFile           string `json:"certFile,omitempty"`
+		KeyFile            string `json:"keyFile,omitempty"`
+		ServerName         string `json:"serverName,omitempty"`
+		InsecureSkipVerify bool   `json:"insecureSkipVerify,omitempty"`
+	}
+
+	// SASLConfig is the SASL section of producer configuration
+	SASLConfig struct {
+		Mechanism string `json:"mechanism"`
+		Username  Secret `json:"username"`
+		Password  Secret `json:"password"`
+	}
+
+	// Secret is a credential given as a value, or read from an environment variable or a file.
+	// It is written as a string or as {"env": "NAME"} or {"file": "path"} in configuration, and
+	// never printed
+	Secret struct {
+		value string
+		env   string
+		file  string
+	}
+
+	secretSource struct {
+		Env  string `json:"env,omitempty"`
+		File string `json:"file,omitempty"`
+	}
+
+	// scramClient is a sarama SCRAM client on top of xdg/scram
+	scramClient struct {
+		hash         scram.HashGeneratorFcn
+		conversation *scram.ClientConversation
+	}
+)
+
+//String hide secret value
+func (s Secret) String() string {
+	switch {
+	case s.env != "":
+		return "env:" + s.env
+	case s.file != "":
+		return "file:" + s.file
+	case s.value != "":
+		return "[redacted]"
+	default:
+		return ""
+	}
+}
+
+//GoString hide secret value
+func (s Secret) GoString() string {
+	return s.String()
+}
+
+//IsZero report whether secret is not set
+func (s Secret) IsZero() bool {
+	return s == Secret{}
+}
+
+//MarshalJSON write secret back the way it is configured
+func (s Secret) MarshalJSON() ([]byte, error) {
+	if s.env != "" || s.file != "" {
+		return json.Marshal(secretSource{Env: s.env, File: s.file})
+	}
+	return json.Marshal(s.value)
+}
+
+//UnmarshalJSON ..
+func (s *Secret) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err == nil {
+		*s = Secret{value: value}
+		return nil
+	}
+	var source secretSource
+	if err := json.Unmarshal(data, &source); err != nil {
+		return fmt.Errorf("secret must be a string, {\"env\": \"NAME\"} or {\"file\": \"path\"}")
+	}
+	if (source.Env == "") == (source.File == "") {
+		return fmt.Errorf("secret must have exactly one of env and file")
+	}
+	*s = Secret{env: source.Env, file: source.File}
+	return nil
+}
+
+//Value get secret value, trailing newline of secret file is dropped
+func (s Secret) Value() (string, error) {
+	switch {
+	case s.env != "":
+		value, ok := os.LookupEnv(s.env)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s is not set", s.env)
+		}
+		return value, nil
+	case s.file != "":
+		content, err := ioutil.ReadFile(s.file)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(content), "\r\n"), nil
+	default:
+		return s.value, nil
+	}
+}
+
+//apply enable TLS on sarama configuration
+func (c *TLSConfig) apply(config *sarama.Config) error {
+	tlsConfig := &tls.Config{
+		ServerName:         c.ServerName,
+		InsecureSkipVerify: c.InsecureSkipVerify,
+	}
+	if c.CAFile != "" {
+		ca, err := ioutil.ReadFile(c.CAFile)
+		if err != nil {
+			return fmt.Errorf("producer.tls.caFile: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return fmt.Errorf("producer.tls.caFile: no PEM certificate found in %s", c.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		return fmt.Errorf("producer.tls.certFile and producer.tls.keyFile must be set together")
+	}
+	if c.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return fmt.Errorf("producer.tls.certFile: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	config.Net.TLS.Enable = true
+	config.Net.TLS.Config = tlsConfig
+	return nil
+}
+
+//apply enable SASL on sarama configuration, credentials are resolved here
+func (c *SASLConfig) apply(config *sarama.Config) error {
+	switch c.Mechanism {
+	case sarama.SASLTypePlaintext:
+	case sarama.SASLTypeSCRAMSHA256:
+		config.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient {
+			return &scramClient{hash: scram.HashGeneratorFcn(sha256.New)}
+		}
+	case sarama.SASLTypeSCRAMSHA512:
+		config.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient {
+			return &scramClient{hash: scram.HashGeneratorFcn(sha512.New)}
+		}
+	default:
+		return fmt.Errorf("producer.sasl.mechanism must be %s, %s or %s, got %q",
+			sarama.SASLTypePlaintext, sarama.SASLTypeSCRAMSHA256, sarama.SASLTypeSCRAMSHA512, c.Mechanism)
+	}
+
+	username, err := c.Username.Value()
+	if err != nil {
+		return fmt.Errorf("producer.sasl.username: %v", err)
+	}
+	if username == "" {
+		return fmt.Errorf("producer.sasl.username must be set")
+	}
+	password, err := c.Password.Value()
+	if err != nil {
+		return fmt.Errorf("producer.sasl.password: %v", err)
+	}
+
+	config.Net.SASL.Enable = true
+	config.Net.SASL.Handshake = true
+	config.Net.SASL.Mechanism = sarama.SASLMechanism(c.Mechanism)
+	config.Net.SASL.User = username
+	config.Net.SASL.Password = password
+	return nil
+}
+
+//Begin ..
+func (c *scramClient) Begin(username, password, authzID string) error {
+	client, err := c.hash.NewClient(username, password, authzID)
+	if err != nil {
+		return err
+	}
+	c.conversation = client.NewConversation()
+	return nil
+}
+
+//Step ..
+func (c *scramClient) Step(challenge string) (string, error) {
+	return c.conversation.Step(challenge)
+}
+
+//Done ..
+func (c *scramClient) Done() bool {
+	return c.conversation.Done()
+}
@@ -0,0 +1,307 @@
+package services_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"kafka-repush/services"
+	"log"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCert is a certificate written to PEM files
+type testCert struct {
+	cert     *x509.Certificate
+	key      *ecdsa.PrivateKey
+	certFile string
+	keyFile  string
+}
+
+//newTestCert create a certificate signed by parent, a self signed CA when parent is nil
+func newTestCert(dir, name string, parent *testCert) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		log.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		log.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		log.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	c := &testCert{
+		cert:     cert,
+		key:      key,
+		certFile: filepath.Join(dir, name+".crt"),
+		keyFile:  filepath.Join(dir, name+".key"),
+	}
+	if err := ioutil.WriteFile(c.certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		log.Fatal(err)
+	}
+	if err := ioutil.WriteFile(c.keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		log.Fatal(err)
+	}
+	return c
+}
+
+//startTLSBroker start a stand-in broker accepting TLS connections from clients with a certificate signed by ca,
+//common names of connected clients are sent to returned channel
+func startTLSBroker(server, ca *testCert) (string, <-chan string, func()) {
+	cert, err := tls.LoadX509KeyPair(server.certFile, server.keyFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	clients := make(chan string, 10)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn *tls.Conn) {
+				defer conn.Close()
+				if err := conn.Handshake(); err != nil {
+					return
+				}
+				clients <- conn.ConnectionState().PeerCertificates[0].Subject.CommonName
+			}(conn.(*tls.Conn))
+		}
+	}()
+	return listener.Addr().String(), clients, func() { listener.Close() }
+}
+
+func TestProducerConfigTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := newTestCert(dir, "ca", nil)
+	server := newTestCert(dir, "broker", ca)
+	client := newTestCert(dir, "repush", ca)
+	addr, clients, stop := startTLSBroker(server, ca)
+	defer stop()
+
+	testCases := []struct {
+		name     string
+		tls      services.TLSConfig
+		accepted bool
+	}{
+		{
+			name:     "Mutual TLS",
+			tls:      services.TLSConfig{CAFile: ca.certFile, CertFile: client.certFile, KeyFile: client.keyFile},
+			accepted: true,
+		},
+		{
+			name: "Unknown CA",
+			tls:  services.TLSConfig{CertFile: client.certFile, KeyFile: client.keyFile},
+		},
+		{
+			name:     "Insecure skip verify",
+			tls:      services.TLSConfig{InsecureSkipVerify: true, CertFile: client.certFile, KeyFile: client.keyFile},
+			accepted: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tlsConfig := tc.tls
+			config, err := services.ProducerConfig{TLS: &tlsConfig}.SaramaConfig()
+			if err != nil {
+				log.Fatal(err)
+			}
+			config.Net.ReadTimeout = time.Second
+
+			// TLS handshake happens with the first request, stand-in broker never answers it
+			broker := sarama.NewBroker(addr)
+			if err := broker.Open(config); err != nil {
+				log.Fatal(err)
+			}
+			_, err = broker.GetMetadata(&sarama.MetadataRequest{})
+			assert.NotNil(t, err)
+			broker.Close()
+
+			wait := 200 * time.Millisecond
+			if tc.accepted {
+				wait = 5 * time.Second
+			}
+			select {
+			case name := <-clients:
+				assert.True(t, tc.accepted)
+				assert.Equal(t, "repush", name)
+			case <-time.After(wait):
+				assert.False(t, tc.accepted, "broker did not accept client certificate")
+			}
+		})
+	}
+}
+
+func TestProducerConfigTLSInvalid(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	client := newTestCert(dir, "repush", nil)
+
+	testCases := []struct {
+		name   string
+		tls    services.TLSConfig
+		expErr string
+	}{
+		{
+			name:   "Missing CA file",
+			tls:    services.TLSConfig{CAFile: filepath.Join(dir, "missing.crt")},
+			expErr: fmt.Sprintf("producer.tls.caFile: open %s: no such file or directory", filepath.Join(dir, "missing.crt")),
+		},
+		{
+			name:   "CA file without certificate",
+			tls:    services.TLSConfig{CAFile: client.keyFile},
+			expErr: fmt.Sprintf("producer.tls.caFile: no PEM certificate found in %s", client.keyFile),
+		},
+		{
+			name:   "Certificate without key",
+			tls:    services.TLSConfig{CertFile: client.certFile},
+			expErr: "producer.tls.certFile and producer.tls.keyFile must be set together",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tlsConfig := tc.tls
+			err := services.ProducerConfig{TLS: &tlsConfig}.Validate()
+			if assert.NotNil(t, err) {
+				assert.Equal(t, tc.expErr, err.Error())
+			}
+		})
+	}
+}
+
+func TestProducerConfigSASL(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sasl")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	passwordFile := filepath.Join(dir, "password")
+	if err := ioutil.WriteFile(passwordFile, []byte("file-secret\n"), 0600); err != nil {
+		log.Fatal(err)
+	}
+	os.Setenv("REPUSH_TEST_PASSWORD", "env-secret")
+	defer os.Unsetenv("REPUSH_TEST_PASSWORD")
+
+	testCases := []struct {
+		name     string
+		sasl     string
+		password string
+		expErr   string
+	}{
+		{
+			name:     "Plain password",
+			sasl:     `{"mechanism":"PLAIN","username":"repush","password":"plain-secret"}`,
+			password: "plain-secret",
+		},
+		{
+			name:     "Password from env",
+			sasl:     `{"mechanism":"SCRAM-SHA-256","username":"repush","password":{"env":"REPUSH_TEST_PASSWORD"}}`,
+			password: "env-secret",
+		},
+		{
+			name:     "Password from file",
+			sasl:     fmt.Sprintf(`{"mechanism":"SCRAM-SHA-512","username":"repush","password":{"file":%q}}`, passwordFile),
+			password: "file-secret",
+		},
+		{
+			name:   "Missing env",
+			sasl:   `{"mechanism":"PLAIN","username":"repush","password":{"env":"REPUSH_TEST_MISSING"}}`,
+			expErr: "producer.sasl.password: environment variable REPUSH_TEST_MISSING is not set",
+		},
+		{
+			name:   "Unknown mechanism",
+			sasl:   `{"mechanism":"GSSAPI","username":"repush","password":"secret"}`,
+			expErr: `producer.sasl.mechanism must be PLAIN, SCRAM-SHA-256 or SCRAM-SHA-512, got "GSSAPI"`,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var sasl services.SASLConfig
+			if err := json.Unmarshal([]byte(tc.sasl), &sasl); err != nil {
+				log.Fatal(err)
+			}
+			config, err := services.ProducerConfig{SASL: &sasl}.SaramaConfig()
+			if tc.expErr != "" {
+				if assert.NotNil(t, err) {
+					assert.Equal(t, tc.expErr, err.Error())
+				}
+				return
+			}
+			assert.Nil(t, err)
+			assert.True(t, config.Net.SASL.Enable)
+			assert.Equal(t, "repush", config.Net.SASL.User)
+			assert.Equal(t, tc.password, config.Net.SASL.Password)
+
+			// Credentials never show up when configuration is printed
+			assert.NotContains(t, fmt.Sprintf("%v %+v %#v", sasl, sasl, sasl), tc.password)
+		})
+	}
+}
+
+func TestSecretMarshalJSON(t *testing.T) {
+	for _, text := range []string{`"secret"`, `{"env":"KAFKA_PASSWORD"}`, `{"file":"/run/secrets/kafka"}`} {
+		var secret services.Secret
+		assert.Nil(t, json.Unmarshal([]byte(text), &secret))
+		data, err := json.Marshal(secret)
+		assert.Nil(t, err)
+		assert.Equal(t, text, string(data))
+	}
+
+	var secret services.Secret
+	assert.NotNil(t, json.Unmarshal([]byte(`{"env":"A","file":"b"}`), &secret))
+}
@@ -2,14 +2,24 @@ package services
 
 import (
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
+	"log"
 	"os"
+	"time"
 )
 
 type (
 	LogInfo struct {
-		Topic   string `json:"topic"`
-		Message string `json:"message"`
+		Topic      string    `json:"topic"`
+		Message    string    `json:"message"`
+		Encoding   string    `json:"encoding,omitempty"`
+		MessageKey *string   `json:"key,omitempty"`
+		Headers    Headers   `json:"headers,omitempty"`
+		Partition  *int32    `json:"partition,omitempty"`
+		Timestamp  time.Time `json:"timestamp,omitempty"`
+		// Null is a message without value, the tombstone of a key in a compacted topic
+		Null bool `json:"null,omitempty"`
 	}
 
 	LogService interface {
@@ -21,11 +31,26 @@ type (
 	}
 
 	LogHandler struct {
-		prod Producer
+		prod            Producer
+		deadLetterTopic string
+		encoding        string
+		topics          *topicRouter
+		transforms      []Transform
+		filter          *Filter
+		maxMessageBytes int
+		decoder         Decoder
 	}
 
 	Config struct {
-		LastLine int64 `json:"lastLine"`
+		LastLine   int64                 `json:"lastLine,omitempty"`
+		Checkpoint *Checkpoint           `json:"checkpoint,omitempty"`
+		Files      map[string]Checkpoint `json:"files,omitempty"`
+		Encoding   string                `json:"messageEncoding,omitempty"`
+		Producer   ProducerConfig        `json:"producer"`
+		Store      CheckpointStoreConfig `json:"checkpointStore"`
+		Topics     TopicRules            `json:"topics"`
+		Transforms []TransformConfig     `json:"transforms,omitempty"`
+		Input      *InputConfig          `json:"input,omitempty"`
 	}
 )
 
@@ -41,25 +66,199 @@ func NewLogHandler(producer Producer) *LogHandler {
 
 //GetLastLine get last read line if any
 func (h *LogHandler) GetConfig(file *os.File) (Config, error) {
+	return ReadConfig(file)
+}
 
+//ReadConfig read service configuration from file
+func ReadConfig(file *os.File) (Config, error) {
 	configByte, err := ioutil.ReadAll(file)
 	if err != nil {
 		return Config{}, err
 	}
+	return parseConfig(configByte)
+}
+
+//LoadConfig read service configuration file, its backup is used when it is corrupt
+func LoadConfig(name string) (Config, error) {
+	configByte, err := ioutil.ReadFile(name)
+	if err != nil {
+		return Config{}, err
+	}
+	config, err := parseConfig(configByte)
+	if err == nil {
+		return config, nil
+	}
+
+	backupByte, backupErr := ioutil.ReadFile(ConfigBackupName(name))
+	if backupErr != nil {
+		return Config{}, err
+	}
+	config, backupErr = parseConfig(backupByte)
+	if backupErr != nil {
+		return Config{}, err
+	}
+	log.Printf("Config %s is corrupt, using backup %s \n", name, ConfigBackupName(name))
+	return config, nil
+}
+
+//ConfigBackupName get name of backup of given configuration file
+func ConfigBackupName(name string) string {
+	return name + ".bak"
+}
+
+//parseConfig parse service configuration
+func parseConfig(configByte []byte) (Config, error) {
 	config := Config{}
-	err = json.Unmarshal(configByte, &config)
+	err := json.Unmarshal(configByte, &config)
 	if err != nil {
 		return Config{}, ErrJsonInput
 	}
+	//Convert line counting config to checkpoint
+	if config.LastLine > 0 && config.Checkpoint == nil {
+		config.Checkpoint = &Checkpoint{Line: config.LastLine}
+	}
+	config.LastLine = 0
 	return config, nil
 }
 
+//SetMaxMessageBytes fail lines whose message is larger than given size instead of sending them,
+//zero size is no limit
+func (h *LogHandler) SetMaxMessageBytes(size int) {
+	h.maxMessageBytes = size
+}
+
 //SendMessage send message to kafka server
 func (h *LogHandler) SendMessage(topic string, msg ProducerMessage) error {
 	return h.prod.Send(topic, msg)
 }
 
-//StoreLastLine store last read line for next log read, created new file if there no such file
+//SendMessageAsync send message to kafka server and call done once it is delivered or failed,
+//done is called before returning when producer is not asynchronous
+func (h *LogHandler) SendMessageAsync(topic string, msg ProducerMessage, done func(error)) {
+	if async, ok := h.prod.(AsyncProducer); ok {
+		async.SendAsync(topic, msg, done)
+		return
+	}
+	done(h.prod.Send(topic, msg))
+}
+
+//Flush wait until every message sent asynchronously is done
+func (h *LogHandler) Flush() error {
+	if async, ok := h.prod.(AsyncProducer); ok {
+		return async.Flush()
+	}
+	return nil
+}
+
+//Transactional report whether messages are sent in kafka transactions
+func (h *LogHandler) Transactional() bool {
+	_, ok := h.prod.(TransactionalProducer)
+	return ok
+}
+
+//BeginTransaction begin kafka transaction, messages sent until it is committed or aborted are part of it
+func (h *LogHandler) BeginTransaction() error {
+	if txn, ok := h.prod.(TransactionalProducer); ok {
+		return txn.Begin()
+	}
+	return ErrNotTransactional
+}
+
+//CommitTransaction commit kafka transaction together with checkpoints of input files,
+//transaction must be aborted on error
+func (h *LogHandler) CommitTransaction(checkpoints map[string]Checkpoint) error {
+	if txn, ok := h.prod.(TransactionalProducer); ok {
+		return txn.Commit(checkpoints)
+	}
+	return ErrNotTransactional
+}
+
+//AbortTransaction abort kafka transaction
+func (h *LogHandler) AbortTransaction() error {
+	if txn, ok := h.prod.(TransactionalProducer); ok {
+		return txn.Abort()
+	}
+	return ErrNotTransactional
+}
+
+//PushLine parse a log line and send it to kafka server, nothing is sent when filter or a transform skip it
+func (h *LogHandler) PushLine(line []byte) error {
+	return h.pushLine(line, h.filter)
+}
+
+func (h *LogHandler) pushLine(line []byte, filter *Filter) error {
+	logInfo, keep, err := h.parseLine(line, filter)
+	if err != nil || !keep {
+		return err
+	}
+	if err := h.SendMessage(logInfo.Topic, logInfo); err != nil {
+		return &PushError{Class: ErrorClassBroker, Topic: logInfo.Topic, Err: err}
+	}
+	return nil
+}
+
+//PushLineAsync parse a log line and send it to kafka server, done is called once it is delivered, failed
+//or skipped
+func (h *LogHandler) PushLineAsync(line []byte, done func(error)) {
+	logInfo, keep, err := h.parseLine(line, h.filter)
+	if err != nil || !keep {
+		done(err)
+		return
+	}
+	h.SendMessageAsync(logInfo.Topic, logInfo, func(err error) {
+		if err != nil {
+			err = &PushError{Class: ErrorClassBroker, Topic: logInfo.Topic, Err: err}
+		}
+		done(err)
+	})
+}
+
+//parseLine parse a log line into the message to send, filter, transforms and topic rules are applied.
+//Keep is false when filter or a transform skip the line
+func (h *LogHandler) parseLine(line []byte, filter *Filter) (LogInfo, bool, error) {
+	logInfo, err := h.decode(line)
+	if err != nil {
+		return LogInfo{}, false, &PushError{Class: ErrorClassParse, Err: err}
+	}
+	if logInfo.Encoding == "" {
+		logInfo.Encoding = h.encoding
+	}
+	if filter != nil && !filter.Match(&logInfo) {
+		return LogInfo{}, false, nil
+	}
+	keep, err := h.transform(&logInfo)
+	if err != nil {
+		return LogInfo{}, false, &PushError{Class: ErrorClassTransform, Topic: logInfo.Topic, Err: err}
+	}
+	if !keep {
+		return LogInfo{}, false, nil
+	}
+	if h.topics != nil {
+		topic, err := h.topics.route(logInfo.Topic)
+		if err != nil {
+			return LogInfo{}, false, &PushError{Class: ErrorClassTopic, Topic: logInfo.Topic, Err: err}
+		}
+		logInfo.Topic = topic
+	}
+	msg, err := logInfo.Encode(logInfo.Topic)
+	if err != nil {
+		return LogInfo{}, false, &PushError{Class: ErrorClassParse, Topic: logInfo.Topic, Err: err}
+	}
+	if h.maxMessageBytes > 0 {
+		// Kafka would reject the message, it is not worth retrying
+		size, err := messageSize(msg)
+		if err != nil {
+			return LogInfo{}, false, &PushError{Class: ErrorClassParse, Topic: logInfo.Topic, Err: err}
+		}
+		if size > int64(h.maxMessageBytes) {
+			err := fmt.Errorf("message of %d bytes exceeds max message bytes %d", size, h.maxMessageBytes)
+			return LogInfo{}, false, &PushError{Class: ErrorClassSize, Topic: logInfo.Topic, Err: err}
+		}
+	}
+	return logInfo, true, nil
+}
+
+//StoreLastLine store last read line for next log read in place, SaveConfig survive a crash while writing
 func (h *LogHandler) StoreConfig(file *os.File, config Config) error {
 	configByte, err := json.Marshal(config)
 	if err != nil {
@@ -77,12 +276,26 @@ func (h *LogHandler) StoreConfig(file *os.File, config Config) error {
 	return nil
 }
 
-//WriteFailPush write failed push message
-func (h *LogHandler) WriteFailPush(file *os.File, msg string) error {
-	if _, err := file.Write([]byte(msg + "\n")); err != nil {
+//SaveConfig replace configuration file through a synced temporary file, so a crash never leave it
+//half written, previous configuration is kept as backup
+func (h *LogHandler) SaveConfig(name string, config Config) error {
+	return writeConfig(name, config)
+}
+
+func writeConfig(name string, config Config) error {
+	configByte, err := json.Marshal(config)
+	if err != nil {
 		return err
 	}
-	return nil
+	return writeFileWithBackup(name, ConfigBackupName(name), configByte, func(previous []byte) bool {
+		_, err := parseConfig(previous)
+		return err == nil
+	})
+}
+
+//WriteFailPush write failed push message as a failure record without details
+func (h *LogHandler) WriteFailPush(file *os.File, msg string) error {
+	return h.WriteFailRecord(file, NewFailRecord([]byte(msg), Position{}, nil))
 }
 
 //Close close kafka producer
@@ -8,6 +8,7 @@ import (
 	"kafka-repush/services"
 	"log"
 	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -49,6 +50,30 @@ func TestGetConfig(t *testing.T) {
 	}
 }
 
+func TestGetConfigLastLine(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mockKafka := NewMockProducer(ctrl)
+	service := services.NewLogHandler(mockKafka)
+
+	configFile, err := ioutil.TempFile("", "conf.*.json")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.Remove(configFile.Name())
+	defer configFile.Close()
+	if _, err := configFile.WriteString(`{"lastLine":12}`); err != nil {
+		log.Fatal(err)
+	}
+	if _, err := configFile.Seek(0, 0); err != nil {
+		log.Fatal(err)
+	}
+
+	config, err := service.GetConfig(configFile)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), config.LastLine)
+	assert.Equal(t, &services.Checkpoint{Line: 12}, config.Checkpoint)
+}
+
 func TestSendMessage(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	mockKafka := NewMockProducer(ctrl)
@@ -95,6 +120,48 @@ func TestSendMessage(t *testing.T) {
 	}
 }
 
+func TestPushLineMaxMessageBytes(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mockKafka := NewMockProducer(ctrl)
+	service := services.NewLogHandler(mockKafka)
+	service.SetMaxMessageBytes(10)
+
+	testCases := []struct {
+		name   string
+		line   string
+		send   bool
+		expErr string
+	}{
+		{
+			name: "Message under limit",
+			line: `{"topic":"orders","message":"12345","key":"12345"}`,
+			send: true,
+		},
+		{
+			name:   "Message over limit",
+			line:   `{"topic":"orders","message":"123456","key":"12345"}`,
+			expErr: "message of 11 bytes exceeds max message bytes 10",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.send {
+				mockKafka.EXPECT().Send("orders", gomock.Any()).Times(1).Return(nil)
+			}
+			err := service.PushLine([]byte(tc.line))
+			if tc.expErr == "" {
+				assert.Nil(t, err)
+				return
+			}
+			var pushErr *services.PushError
+			if assert.True(t, errors.As(err, &pushErr)) {
+				assert.Equal(t, services.ErrorClassSize, pushErr.Class)
+				assert.Equal(t, tc.expErr, pushErr.Error())
+			}
+		})
+	}
+}
+
 func TestStoreConfig(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	mockKafka := NewMockProducer(ctrl)
@@ -240,3 +307,100 @@ func TestClose(t *testing.T) {
 		})
 	}
 }
+
+func TestSaveConfig(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mockKafka := NewMockProducer(ctrl)
+	service := services.NewLogHandler(mockKafka)
+
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	configName := filepath.Join(dir, "conf.json")
+
+	first := services.Config{Files: map[string]services.Checkpoint{"log.txt": {Offset: 10, Line: 1}}}
+	second := services.Config{Files: map[string]services.Checkpoint{"log.txt": {Offset: 20, Line: 2}}}
+	assert.Nil(t, service.SaveConfig(configName, first))
+	assert.Nil(t, service.SaveConfig(configName, second))
+
+	config, err := services.LoadConfig(configName)
+	assert.Nil(t, err)
+	assert.Equal(t, second.Files, config.Files)
+	backup, err := services.LoadConfig(services.ConfigBackupName(configName))
+	assert.Nil(t, err)
+	assert.Equal(t, first.Files, backup.Files)
+
+	// Only config and its backup are left behind
+	entries, err := ioutil.ReadDir(dir)
+	assert.Nil(t, err)
+	assert.Len(t, entries, 2)
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	configName := filepath.Join(dir, "conf.json")
+	backupName := services.ConfigBackupName(configName)
+
+	testCases := []struct {
+		name   string
+		config string
+		backup string
+		offset int64
+		expErr error
+	}{
+		{
+			name:   "Valid config",
+			config: `{"files":{"log.txt":{"offset":20}}}`,
+			backup: `{"files":{"log.txt":{"offset":10}}}`,
+			offset: 20,
+		},
+		{
+			name:   "Empty config",
+			config: ``,
+			backup: `{"files":{"log.txt":{"offset":10}}}`,
+			offset: 10,
+		},
+		{
+			name:   "Truncated config",
+			config: `{"files":{"log.t`,
+			backup: `{"files":{"log.txt":{"offset":10}}}`,
+			offset: 10,
+		},
+		{
+			name:   "Corrupt config and backup",
+			config: `{"files":{"log.t`,
+			backup: `{"fil`,
+			expErr: services.ErrJsonInput,
+		},
+		{
+			name:   "Corrupt config without backup",
+			config: `{"files":{"log.t`,
+			expErr: services.ErrJsonInput,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := ioutil.WriteFile(configName, []byte(tc.config), 0644); err != nil {
+				log.Fatal(err)
+			}
+			os.Remove(backupName)
+			if tc.backup != "" {
+				if err := ioutil.WriteFile(backupName, []byte(tc.backup), 0644); err != nil {
+					log.Fatal(err)
+				}
+			}
+
+			config, err := services.LoadConfig(configName)
+			assert.Equal(t, tc.expErr, err)
+			if tc.expErr == nil {
+				assert.Equal(t, tc.offset, config.FileCheckpoint("log.txt").Offset)
+			}
+		})
+	}
+}
@@ -0,0 +1,201 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+)
+
+// Checkpoint store types
+const (
+	StoreTypeConfig = "config"
+	StoreTypeFile   = "file"
+	StoreTypeBolt   = "bbolt"
+	StoreTypeKafka  = "kafka"
+)
+
+type (
+	// CheckpointStore keep checkpoints of input files between runs
+	CheckpointStore interface {
+		Load() (map[string]Checkpoint, error)
+		Save(checkpoints map[string]Checkpoint) error
+		Close() error
+	}
+
+	// CheckpointStoreConfig is the checkpoint store section of service configuration, checkpoints are kept
+	// in configuration file when type is not set
+	CheckpointStoreConfig struct {
+		Type  string `json:"type,omitempty"`
+		Path  string `json:"path,omitempty"`
+		Topic string `json:"topic,omitempty"`
+		ID    string `json:"id,omitempty"`
+	}
+
+	// configStore keep checkpoints in service configuration file
+	configStore struct {
+		name   string
+		config *Config
+	}
+
+	// FileCheckpointStore keep checkpoints in a JSON file of their own
+	FileCheckpointStore struct {
+		name string
+	}
+
+	// checkpointFile is the content of checkpoint file
+	checkpointFile struct {
+		Files map[string]Checkpoint `json:"files"`
+	}
+)
+
+//NewCheckpointStore create checkpoint store of service configuration read from configName, brokers are
+//used by kafka store
+func NewCheckpointStore(configName string, config *Config, brokers []string) (CheckpointStore, error) {
+	conf := config.Store
+	if err := conf.check(); err != nil {
+		return nil, err
+	}
+	switch conf.Type {
+	case StoreTypeFile:
+		return NewFileCheckpointStore(conf.Path), nil
+	case StoreTypeBolt:
+		return OpenBoltCheckpointStore(conf.Path)
+	case StoreTypeKafka:
+		return OpenKafkaCheckpointStore(brokers, config.Producer, conf)
+	default:
+		return &configStore{name: configName, config: config}, nil
+	}
+}
+
+//ReadCheckpoints read checkpoints of service configuration without taking hold of its checkpoint store, so
+//they can be looked at while a repusher is running. Checkpoints committed by a transactional producer take
+//over the ones of the store, brokers are used when checkpoints are kept in kafka
+func ReadCheckpoints(configName string, config *Config, brokers []string) (map[string]Checkpoint, error) {
+	conf := config.Store
+	if err := conf.check(); err != nil {
+		return nil, err
+	}
+	var checkpoints map[string]Checkpoint
+	var err error
+	switch conf.Type {
+	case StoreTypeFile:
+		checkpoints, err = NewFileCheckpointStore(conf.Path).Load()
+	case StoreTypeBolt:
+		checkpoints, err = ReadBoltCheckpoints(conf.Path)
+	case StoreTypeKafka:
+		checkpoints, err = ReadKafkaCheckpoints(brokers, config.Producer, conf)
+	default:
+		checkpoints = copyCheckpoints(config.Files)
+	}
+	if err != nil || config.Producer.Transaction == nil {
+		return checkpoints, err
+	}
+
+	committed, err := LoadCheckpoints(brokers, config.Producer)
+	if err != nil {
+		return nil, err
+	}
+	for name, cp := range committed {
+		checkpoints[name] = cp
+	}
+	return checkpoints, nil
+}
+
+//CheckpointsInKafka report whether checkpoints of configuration are kept in kafka, reading them need brokers
+func (c *Config) CheckpointsInKafka() bool {
+	return c.Store.Type == StoreTypeKafka || c.Producer.Transaction != nil
+}
+
+//check validate checkpoint store configuration
+func (c CheckpointStoreConfig) check() error {
+	switch c.Type {
+	case "", StoreTypeConfig:
+		return nil
+	case StoreTypeFile, StoreTypeBolt:
+		if c.Path == "" {
+			return fmt.Errorf("checkpointStore.path must be set for %s store", c.Type)
+		}
+		return nil
+	case StoreTypeKafka:
+		if c.Topic == "" {
+			return fmt.Errorf("checkpointStore.topic must be set for %s store", c.Type)
+		}
+		return nil
+	default:
+		return fmt.Errorf("checkpointStore.type must be %s, %s, %s or %s, got %q",
+			StoreTypeConfig, StoreTypeFile, StoreTypeBolt, StoreTypeKafka, c.Type)
+	}
+}
+
+//Load get checkpoints read with configuration
+func (s *configStore) Load() (map[string]Checkpoint, error) {
+	return s.config.Files, nil
+}
+
+//Save write configuration with given checkpoints
+func (s *configStore) Save(checkpoints map[string]Checkpoint) error {
+	config := *s.config
+	config.Files = checkpoints
+	return writeConfig(s.name, config)
+}
+
+//Close ..
+func (s *configStore) Close() error {
+	return nil
+}
+
+//NewFileCheckpointStore create store keeping checkpoints in given file, previous content is kept as backup
+func NewFileCheckpointStore(name string) *FileCheckpointStore {
+	return &FileCheckpointStore{name: name}
+}
+
+//Load read checkpoint file, its backup is used when it is corrupt, no checkpoint is found without file
+func (s *FileCheckpointStore) Load() (map[string]Checkpoint, error) {
+	checkpoints, err := readCheckpointFile(s.name)
+	if os.IsNotExist(err) {
+		return map[string]Checkpoint{}, nil
+	}
+	if err == nil {
+		return checkpoints, nil
+	}
+	backup, backupErr := readCheckpointFile(ConfigBackupName(s.name))
+	if backupErr != nil {
+		return nil, err
+	}
+	log.Printf("Checkpoint file %s is corrupt, using backup %s \n", s.name, ConfigBackupName(s.name))
+	return backup, nil
+}
+
+//Save replace checkpoint file atomically
+func (s *FileCheckpointStore) Save(checkpoints map[string]Checkpoint) error {
+	data, err := json.Marshal(checkpointFile{Files: checkpoints})
+	if err != nil {
+		return err
+	}
+	return writeFileWithBackup(s.name, ConfigBackupName(s.name), data, func(previous []byte) bool {
+		return json.Unmarshal(previous, &checkpointFile{}) == nil
+	})
+}
+
+//Close ..
+func (s *FileCheckpointStore) Close() error {
+	return nil
+}
+
+//readCheckpointFile read checkpoints of checkpoint file
+func readCheckpointFile(name string) (map[string]Checkpoint, error) {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	var content checkpointFile
+	if err := json.Unmarshal(data, &content); err != nil {
+		return nil, ErrJsonInput
+	}
+	if content.Files == nil {
+		content.Files = make(map[string]Checkpoint)
+	}
+	return content.Files, nil
+}
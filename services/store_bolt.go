@@ -0,0 +1,125 @@
+package services
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// checkpointBucket is the bbolt bucket of checkpoints, keyed by input file name
+var checkpointBucket = []byte("checkpoints")
+
+// BoltCheckpointStore keep checkpoints in an embedded bbolt database
+type BoltCheckpointStore struct {
+	db *bolt.DB
+}
+
+//OpenBoltCheckpointStore open bbolt database at given path, it is created if there no such file
+func OpenBoltCheckpointStore(path string) (*BoltCheckpointStore, error) {
+	// Another repusher holding the database must not block this one forever
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: 10 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(checkpointBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &BoltCheckpointStore{db: db}, nil
+}
+
+//ReadBoltCheckpoints read checkpoints of bbolt database at given path from a copy of it, the database itself is
+//not locked so a repusher holding it is not waited for. No checkpoint is found without database
+func ReadBoltCheckpoints(path string) (map[string]Checkpoint, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return map[string]Checkpoint{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	snapshot, err := ioutil.TempFile("", filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(snapshot.Name())
+	if _, err := snapshot.Write(data); err != nil {
+		snapshot.Close()
+		return nil, err
+	}
+	if err := snapshot.Close(); err != nil {
+		return nil, err
+	}
+
+	db, err := bolt.Open(snapshot.Name(), 0600, &bolt.Options{ReadOnly: true, Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	store := &BoltCheckpointStore{db: db}
+	defer store.Close()
+	return store.Load()
+}
+
+//Load read every checkpoint of database
+func (s *BoltCheckpointStore) Load() (map[string]Checkpoint, error) {
+	checkpoints := make(map[string]Checkpoint)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(checkpointBucket).ForEach(func(key, value []byte) error {
+			var cp Checkpoint
+			if err := json.Unmarshal(value, &cp); err != nil {
+				return err
+			}
+			checkpoints[string(key)] = cp
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return checkpoints, nil
+}
+
+//Save replace checkpoints of database in a single transaction
+func (s *BoltCheckpointStore) Save(checkpoints map[string]Checkpoint) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(checkpointBucket)
+		var stale [][]byte
+		err := bucket.ForEach(func(key, _ []byte) error {
+			if _, ok := checkpoints[string(key)]; !ok {
+				stale = append(stale, append([]byte(nil), key...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, key := range stale {
+			if err := bucket.Delete(key); err != nil {
+				return err
+			}
+		}
+		for name, cp := range checkpoints {
+			value, err := json.Marshal(cp)
+			if err != nil {
+				return err
+			}
+			if err := bucket.Put([]byte(name), value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+//Close close database
+func (s *BoltCheckpointStore) Close() error {
+	return s.db.Close()
+}
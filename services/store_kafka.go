@@ -0,0 +1,132 @@
+package services
+
+import (
+	"encoding/json"
+
+	"github.com/Shopify/sarama"
+)
+
+// KafkaCheckpointStore keep checkpoints in a compacted kafka topic, one record per input file
+type KafkaCheckpointStore struct {
+	client   sarama.Client
+	producer sarama.SyncProducer
+	id       string
+	topic    string
+	saved    map[string]Checkpoint
+}
+
+//OpenKafkaCheckpointStore connect to kafka with producer configuration for storing checkpoints in topic of
+//store configuration, store id tell apart repushers sharing the topic
+func OpenKafkaCheckpointStore(brokers []string, producerConf ProducerConfig, conf CheckpointStoreConfig) (*KafkaCheckpointStore, error) {
+	// Checkpoints are sent one batch at a time, outside of any transaction
+	producerConf.Async = false
+	producerConf.BatchSize = 0
+	producerConf.Linger = 0
+	producerConf.Transaction = nil
+	config, err := producerConf.SaramaConfig()
+	if err != nil {
+		return nil, err
+	}
+	client, err := sarama.NewClient(brokers, config)
+	if err != nil {
+		return nil, err
+	}
+	producer, err := sarama.NewSyncProducerFromClient(client)
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	return NewKafkaCheckpointStore(client, producer, conf.ID, conf.Topic), nil
+}
+
+//ReadKafkaCheckpoints read checkpoints of kafka store of given configuration without opening a producer
+func ReadKafkaCheckpoints(brokers []string, producerConf ProducerConfig, conf CheckpointStoreConfig) (map[string]Checkpoint, error) {
+	producerConf.Transaction = nil
+	config, err := producerConf.SaramaConfig()
+	if err != nil {
+		return nil, err
+	}
+	client, err := sarama.NewClient(brokers, config)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+	return loadCheckpoints(client, conf.ID, conf.Topic)
+}
+
+//NewKafkaCheckpointStore create store reading checkpoints with client and writing them with producer
+func NewKafkaCheckpointStore(client sarama.Client, producer sarama.SyncProducer, id string, topic string) *KafkaCheckpointStore {
+	return &KafkaCheckpointStore{
+		client:   client,
+		producer: producer,
+		id:       id,
+		topic:    topic,
+		saved:    make(map[string]Checkpoint),
+	}
+}
+
+//Load read latest checkpoint of each input file from topic
+func (s *KafkaCheckpointStore) Load() (map[string]Checkpoint, error) {
+	checkpoints, err := loadCheckpoints(s.client, s.id, s.topic)
+	if err != nil {
+		return nil, err
+	}
+	s.saved = copyCheckpoints(checkpoints)
+	return checkpoints, nil
+}
+
+//Save send checkpoints changed since last save, removed checkpoints are deleted with a tombstone
+func (s *KafkaCheckpointStore) Save(checkpoints map[string]Checkpoint) error {
+	var msgs []*sarama.ProducerMessage
+	for name, cp := range checkpoints {
+		if saved, ok := s.saved[name]; ok && saved == cp {
+			continue
+		}
+		value, err := json.Marshal(committedCheckpoint{ID: s.id, File: name, Checkpoint: cp})
+		if err != nil {
+			return err
+		}
+		msgs = append(msgs, &sarama.ProducerMessage{
+			Topic:     s.topic,
+			Key:       sarama.StringEncoder(checkpointKey(s.id, name)),
+			Partition: -1,
+			Value:     sarama.ByteEncoder(value),
+			Metadata:  checkpointRecord{},
+		})
+	}
+	for name := range s.saved {
+		if _, ok := checkpoints[name]; !ok {
+			msgs = append(msgs, &sarama.ProducerMessage{
+				Topic:     s.topic,
+				Key:       sarama.StringEncoder(checkpointKey(s.id, name)),
+				Partition: -1,
+				Metadata:  checkpointRecord{},
+			})
+		}
+	}
+	if len(msgs) == 0 {
+		return nil
+	}
+	if err := s.producer.SendMessages(msgs); err != nil {
+		return err
+	}
+	s.saved = copyCheckpoints(checkpoints)
+	return nil
+}
+
+//Close close producer and kafka client
+func (s *KafkaCheckpointStore) Close() error {
+	if err := s.producer.Close(); err != nil {
+		s.client.Close()
+		return err
+	}
+	return s.client.Close()
+}
+
+func copyCheckpoints(checkpoints map[string]Checkpoint) map[string]Checkpoint {
+	copied := make(map[string]Checkpoint, len(checkpoints))
+	for name, cp := range checkpoints {
+		copied[name] = cp
+	}
+	return copied
+}
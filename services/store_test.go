@@ -0,0 +1,221 @@
+package services_test
+
+import (
+	"encoding/json"
+	"github.com/Shopify/sarama"
+	"github.com/Shopify/sarama/mocks"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"kafka-repush/services"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCheckpointStores(t *testing.T) {
+	dir, err := ioutil.TempDir("", "store")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	configName := filepath.Join(dir, "conf.json")
+	if err := ioutil.WriteFile(configName, []byte(`{}`), 0644); err != nil {
+		log.Fatal(err)
+	}
+
+	testCases := []struct {
+		name  string
+		store services.CheckpointStoreConfig
+	}{
+		{
+			name: "Config file",
+		},
+		{
+			name:  "Checkpoint file",
+			store: services.CheckpointStoreConfig{Type: services.StoreTypeFile, Path: filepath.Join(dir, "checkpoints.json")},
+		},
+		{
+			name:  "Bolt database",
+			store: services.CheckpointStoreConfig{Type: services.StoreTypeBolt, Path: filepath.Join(dir, "checkpoints.db")},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			config := services.Config{Store: tc.store}
+			store, err := services.NewCheckpointStore(configName, &config, nil)
+			if err != nil {
+				log.Fatal(err)
+			}
+			first := map[string]services.Checkpoint{
+				"/var/log/a.log": {Offset: 10, Line: 1},
+				"/var/log/b.log": {Offset: 20, Line: 2},
+			}
+			second := map[string]services.Checkpoint{
+				"/var/log/a.log": {Offset: 30, Line: 3},
+			}
+			assert.Nil(t, store.Save(first))
+			assert.Nil(t, store.Save(second))
+			assert.Nil(t, store.Close())
+
+			// Checkpoints survive a restart
+			reloaded := services.Config{Store: tc.store}
+			if tc.store.Type == "" {
+				if reloaded, err = services.LoadConfig(configName); err != nil {
+					log.Fatal(err)
+				}
+			}
+			store, err = services.NewCheckpointStore(configName, &reloaded, nil)
+			if err != nil {
+				log.Fatal(err)
+			}
+			defer store.Close()
+			checkpoints, err := store.Load()
+			assert.Nil(t, err)
+			assert.Equal(t, second, checkpoints)
+		})
+	}
+}
+
+func TestFileCheckpointStoreCorrupt(t *testing.T) {
+	dir, err := ioutil.TempDir("", "store")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "checkpoints.json")
+
+	store := services.NewFileCheckpointStore(name)
+	first := map[string]services.Checkpoint{"/var/log/a.log": {Offset: 10}}
+	assert.Nil(t, store.Save(first))
+	assert.Nil(t, store.Save(map[string]services.Checkpoint{"/var/log/a.log": {Offset: 20}}))
+	if err := ioutil.WriteFile(name, []byte(`{"files":{"/var/lo`), 0644); err != nil {
+		log.Fatal(err)
+	}
+
+	checkpoints, err := store.Load()
+	assert.Nil(t, err)
+	assert.Equal(t, first, checkpoints)
+}
+
+func TestReadCheckpointsOfOpenStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "store")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Running repusher hold the bbolt database lock
+	config := services.Config{Store: services.CheckpointStoreConfig{Type: services.StoreTypeBolt, Path: filepath.Join(dir, "checkpoints.db")}}
+	store, err := services.NewCheckpointStore(filepath.Join(dir, "conf.json"), &config, nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer store.Close()
+	saved := map[string]services.Checkpoint{"/var/log/a.log": {Offset: 10, Line: 1}}
+	assert.Nil(t, store.Save(saved))
+
+	start := time.Now()
+	checkpoints, err := services.ReadCheckpoints(filepath.Join(dir, "conf.json"), &config, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, saved, checkpoints)
+	assert.True(t, time.Since(start) < time.Second)
+}
+
+func TestNewCheckpointStoreInvalid(t *testing.T) {
+	testCases := []struct {
+		name   string
+		store  services.CheckpointStoreConfig
+		expErr string
+	}{
+		{
+			name:   "Unknown type",
+			store:  services.CheckpointStoreConfig{Type: "redis"},
+			expErr: `checkpointStore.type must be config, file, bbolt or kafka, got "redis"`,
+		},
+		{
+			name:   "Bolt without path",
+			store:  services.CheckpointStoreConfig{Type: services.StoreTypeBolt},
+			expErr: "checkpointStore.path must be set for bbolt store",
+		},
+		{
+			name:   "Kafka without topic",
+			store:  services.CheckpointStoreConfig{Type: services.StoreTypeKafka},
+			expErr: "checkpointStore.topic must be set for kafka store",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := services.NewCheckpointStore("conf.json", &services.Config{Store: tc.store}, nil)
+			if assert.NotNil(t, err) {
+				assert.Equal(t, tc.expErr, err.Error())
+			}
+		})
+	}
+}
+
+func TestKafkaCheckpointStore(t *testing.T) {
+	broker := sarama.NewMockBroker(t, 1)
+	defer broker.Close()
+
+	topic := "repush-checkpoints"
+	record := func(file string, offset int64) sarama.Encoder {
+		value, err := json.Marshal(map[string]interface{}{
+			"transactionalId": "pod",
+			"file":            file,
+			"checkpoint":      services.Checkpoint{Offset: offset},
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+		return sarama.ByteEncoder(value)
+	}
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetLeader(topic, 0, broker.BrokerID()),
+		"OffsetRequest": sarama.NewMockOffsetResponse(t).
+			SetOffset(topic, 0, sarama.OffsetOldest, 0).
+			SetOffset(topic, 0, sarama.OffsetNewest, 3),
+		"FetchRequest": sarama.NewMockFetchResponse(t, 10).
+			SetMessageWithKey(topic, 0, 0, sarama.StringEncoder("pod:/var/log/a.log"), record("/var/log/a.log", 10)).
+			SetMessageWithKey(topic, 0, 1, sarama.StringEncoder("pod:/var/log/b.log"), record("/var/log/b.log", 20)).
+			SetMessageWithKey(topic, 0, 2, sarama.StringEncoder("pod:/var/log/b.log"), nil).
+			SetHighWaterMark(topic, 0, 3),
+	})
+
+	config := sarama.NewConfig()
+	client, err := sarama.NewClient([]string{broker.Addr()}, config)
+	if err != nil {
+		log.Fatal(err)
+	}
+	config.Producer.Return.Successes = true
+	producer := mocks.NewSyncProducer(t, config)
+	store := services.NewKafkaCheckpointStore(client, producer, "pod", topic)
+
+	// Tombstone remove checkpoint of b.log
+	checkpoints, err := store.Load()
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]services.Checkpoint{"/var/log/a.log": {Offset: 10}}, checkpoints)
+
+	// Only changed checkpoints are sent
+	producer.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(func(msg *sarama.ProducerMessage) error {
+		key, _ := msg.Key.Encode()
+		assert.Equal(t, "pod:/var/log/c.log", string(key))
+		return nil
+	})
+	checkpoints["/var/log/c.log"] = services.Checkpoint{Offset: 5}
+	assert.Nil(t, store.Save(checkpoints))
+
+	producer.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(func(msg *sarama.ProducerMessage) error {
+		key, _ := msg.Key.Encode()
+		assert.Equal(t, "pod:/var/log/a.log", string(key))
+		assert.Nil(t, msg.Value)
+		return nil
+	})
+	delete(checkpoints, "/var/log/a.log")
+	assert.Nil(t, store.Save(checkpoints))
+	assert.Nil(t, store.Close())
+}
@@ -0,0 +1,154 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+type (
+	// TopicRules restrict topics which lines may be pushed to and rename them. Topics are given
+	// by exact name or by a regular expression between slashes like "/orders-.*/", matching whole topic
+	TopicRules struct {
+		// Allow is the list of allowed topics, every topic is allowed when it is empty
+		Allow []string `json:"allow,omitempty"`
+		// Deny is the list of denied topics, it takes precedence over Allow
+		Deny []string `json:"deny,omitempty"`
+		// Rename map topic of lines, first matching rule is used. Expansions like $1 can be
+		// used with a regular expression
+		Rename []TopicRename `json:"rename,omitempty"`
+	}
+
+	// TopicRename send lines of a topic to another topic
+	TopicRename struct {
+		From string `json:"from"`
+		To   string `json:"to"`
+	}
+
+	// topicRouter check and rename topics with compiled topic rules
+	topicRouter struct {
+		allow  []topicPattern
+		deny   []topicPattern
+		rename []topicRename
+	}
+
+	topicPattern struct {
+		name string
+		re   *regexp.Regexp
+	}
+
+	topicRename struct {
+		from topicPattern
+		to   string
+	}
+)
+
+//Empty report whether there is no rule
+func (r TopicRules) Empty() bool {
+	return len(r.Allow) == 0 && len(r.Deny) == 0 && len(r.Rename) == 0
+}
+
+//Validate check topic names and regular expressions of rules
+func (r TopicRules) Validate() error {
+	_, err := newTopicRouter(r)
+	return err
+}
+
+//SetTopicRules check and rename topic of lines with given rules, lines of a rejected topic
+//fail with topic error class
+func (h *LogHandler) SetTopicRules(rules TopicRules) error {
+	if rules.Empty() {
+		h.topics = nil
+		return nil
+	}
+	router, err := newTopicRouter(rules)
+	if err != nil {
+		return err
+	}
+	h.topics = router
+	return nil
+}
+
+func newTopicRouter(rules TopicRules) (*topicRouter, error) {
+	router := &topicRouter{}
+	for i, name := range rules.Allow {
+		p, err := newTopicPattern(name)
+		if err != nil {
+			return nil, fmt.Errorf("topics.allow[%d]: %v", i, err)
+		}
+		router.allow = append(router.allow, p)
+	}
+	for i, name := range rules.Deny {
+		p, err := newTopicPattern(name)
+		if err != nil {
+			return nil, fmt.Errorf("topics.deny[%d]: %v", i, err)
+		}
+		router.deny = append(router.deny, p)
+	}
+	for i, rename := range rules.Rename {
+		p, err := newTopicPattern(rename.From)
+		if err != nil {
+			return nil, fmt.Errorf("topics.rename[%d].from: %v", i, err)
+		}
+		if rename.To == "" {
+			return nil, fmt.Errorf("topics.rename[%d].to must be set", i)
+		}
+		router.rename = append(router.rename, topicRename{from: p, to: rename.To})
+	}
+	return router, nil
+}
+
+//newTopicPattern compile topic name, or regular expression when it is between slashes
+func newTopicPattern(name string) (topicPattern, error) {
+	if len(name) > 2 && strings.HasPrefix(name, "/") && strings.HasSuffix(name, "/") {
+		re, err := regexp.Compile("^(?:" + name[1:len(name)-1] + ")$")
+		if err != nil {
+			return topicPattern{}, fmt.Errorf("invalid topic regular expression %s: %v", name, err)
+		}
+		return topicPattern{name: name, re: re}, nil
+	}
+	if name == "" {
+		return topicPattern{}, errors.New("topic must not be empty")
+	}
+	return topicPattern{name: name}, nil
+}
+
+func (p topicPattern) match(topic string) bool {
+	if p.re != nil {
+		return p.re.MatchString(topic)
+	}
+	return p.name == topic
+}
+
+//route check topic of a line against deny and allow rules then rename it, rules apply to topic
+//given by transforms
+func (r *topicRouter) route(topic string) (string, error) {
+	for _, p := range r.deny {
+		if p.match(topic) {
+			return "", fmt.Errorf("topic %q is denied by rule %s", topic, p.name)
+		}
+	}
+	if len(r.allow) > 0 {
+		allowed := false
+		for _, p := range r.allow {
+			if p.match(topic) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return "", fmt.Errorf("topic %q is not allowed", topic)
+		}
+	}
+	for _, rename := range r.rename {
+		if !rename.from.match(topic) {
+			continue
+		}
+		if rename.from.re != nil {
+			return rename.from.re.ReplaceAllString(topic, rename.to), nil
+		}
+		return rename.to, nil
+	}
+	return topic, nil
+}
@@ -0,0 +1,119 @@
+package services_test
+
+import (
+	"errors"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"kafka-repush/services"
+	"testing"
+)
+
+func TestTopicRules(t *testing.T) {
+	rules := services.TopicRules{
+		Allow: []string{"orders", "/users-.*/", "payments"},
+		Deny:  []string{"/.*-internal/"},
+		Rename: []services.TopicRename{
+			{From: "orders", To: "orders-replay"},
+			{From: "/users-(.*)/", To: "replay-users-$1"},
+		},
+	}
+	testCases := []struct {
+		name     string
+		line     string
+		expTopic string
+		expErr   string
+	}{
+		{
+			name:     "Renamed by name",
+			line:     `{"topic":"orders","message":"hello"}`,
+			expTopic: "orders-replay",
+		},
+		{
+			name:     "Renamed by regular expression",
+			line:     `{"topic":"users-eu","message":"hello"}`,
+			expTopic: "replay-users-eu",
+		},
+		{
+			name:     "Allowed as is",
+			line:     `{"topic":"payments","message":"hello"}`,
+			expTopic: "payments",
+		},
+		{
+			name:   "Denied",
+			line:   `{"topic":"users-internal","message":"hello"}`,
+			expErr: `topic "users-internal" is denied by rule /.*-internal/`,
+		},
+		{
+			name:   "Not allowed",
+			line:   `{"topic":"order","message":"hello"}`,
+			expErr: `topic "order" is not allowed`,
+		},
+		{
+			name:   "Regular expression match whole topic",
+			line:   `{"topic":"old-users-eu","message":"hello"}`,
+			expErr: `topic "old-users-eu" is not allowed`,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			mockKafka := NewMockProducer(ctrl)
+			if tc.expTopic != "" {
+				mockKafka.EXPECT().Send(tc.expTopic, gomock.Any()).Return(nil)
+			}
+			service := services.NewLogHandler(mockKafka)
+			assert.Nil(t, service.SetTopicRules(rules))
+
+			err := service.PushLine([]byte(tc.line))
+			if tc.expErr == "" {
+				assert.Nil(t, err)
+				return
+			}
+			var pushErr *services.PushError
+			if assert.True(t, errors.As(err, &pushErr)) {
+				assert.Equal(t, services.ErrorClassTopic, pushErr.Class)
+				assert.Equal(t, tc.expErr, pushErr.Error())
+			}
+		})
+	}
+}
+
+func TestTopicRulesValidate(t *testing.T) {
+	testCases := []struct {
+		name   string
+		rules  services.TopicRules
+		expErr string
+	}{
+		{
+			name:  "No rules",
+			rules: services.TopicRules{},
+		},
+		{
+			name:   "Invalid regular expression",
+			rules:  services.TopicRules{Deny: []string{"orders", "/orders-(/"}},
+			expErr: "topics.deny[1]: invalid topic regular expression /orders-(/: error parsing regexp: missing closing ): `^(?:orders-()$`",
+		},
+		{
+			name:   "Empty topic",
+			rules:  services.TopicRules{Allow: []string{""}},
+			expErr: "topics.allow[0]: topic must not be empty",
+		},
+		{
+			name:   "Rename without target",
+			rules:  services.TopicRules{Rename: []services.TopicRename{{From: "orders"}}},
+			expErr: "topics.rename[0].to must be set",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.rules.Validate()
+			if tc.expErr == "" {
+				assert.Nil(t, err)
+				return
+			}
+			if assert.NotNil(t, err) {
+				assert.Equal(t, tc.expErr, err.Error())
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package services
+
+import "sync"
+
+type (
+	// CheckpointTracker give the checkpoint up to which every line is done, that is delivered or
+	// recorded as failure, while lines are sent asynchronously and complete in any order
+	CheckpointTracker struct {
+		mu        sync.Mutex
+		pending   []*trackedLine
+		committed Checkpoint
+	}
+
+	trackedLine struct {
+		cp   Checkpoint
+		done bool
+	}
+)
+
+//NewCheckpointTracker track lines read after given checkpoint
+func NewCheckpointTracker(cp Checkpoint) *CheckpointTracker {
+	return &CheckpointTracker{committed: cp}
+}
+
+//Add track a line by the checkpoint right after it, returned function mark the line as done
+func (t *CheckpointTracker) Add(cp Checkpoint) func() {
+	line := &trackedLine{cp: cp}
+	t.mu.Lock()
+	t.pending = append(t.pending, line)
+	t.mu.Unlock()
+
+	return func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		line.done = true
+		for len(t.pending) > 0 && t.pending[0].done {
+			t.committed = t.pending[0].cp
+			t.pending[0] = nil
+			t.pending = t.pending[1:]
+		}
+	}
+}
+
+//Committed get checkpoint right after the last line done with every line before it done as well
+func (t *CheckpointTracker) Committed() Checkpoint {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.committed
+}
+
+//Pending get number of lines which are not committed yet
+func (t *CheckpointTracker) Pending() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.pending)
+}
@@ -0,0 +1,28 @@
+package services_test
+
+import (
+	"github.com/stretchr/testify/assert"
+	"kafka-repush/services"
+	"testing"
+)
+
+func TestCheckpointTracker(t *testing.T) {
+	start := services.Checkpoint{Offset: 10, Line: 1}
+	tracker := services.NewCheckpointTracker(start)
+
+	first := tracker.Add(services.Checkpoint{Offset: 20, Line: 2})
+	second := tracker.Add(services.Checkpoint{Offset: 30, Line: 3})
+	third := tracker.Add(services.Checkpoint{Offset: 40, Line: 4})
+	assert.Equal(t, start, tracker.Committed())
+	assert.Equal(t, 3, tracker.Pending())
+
+	// Later lines done first must wait for earlier ones
+	third()
+	second()
+	assert.Equal(t, start, tracker.Committed())
+	assert.Equal(t, 3, tracker.Pending())
+
+	first()
+	assert.Equal(t, services.Checkpoint{Offset: 40, Line: 4}, tracker.Committed())
+	assert.Equal(t, 0, tracker.Pending())
+}
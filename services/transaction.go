@@ -0,0 +1,345 @@
+package services
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// DefaultTransactionLines is the number of lines sent per transaction when it is not configured
+const DefaultTransactionLines = 1000
+
+// checkpointLoadTimeout is how long reading a partition of checkpoint topic up to its last stable
+// offset may take, a slow broker or a leader move only delay the load
+const checkpointLoadTimeout = 2 * time.Minute
+
+type (
+	// TransactionConfig is the exactly-once section of producer configuration, lines are sent in kafka
+	// transactions together with the checkpoint of their input file
+	TransactionConfig struct {
+		ID              string   `json:"id"`
+		CheckpointTopic string   `json:"checkpointTopic"`
+		Lines           int      `json:"lines,omitempty"`
+		Timeout         Duration `json:"timeout,omitempty"`
+	}
+
+	// TransactionalProducer is a producer sending messages in kafka transactions
+	TransactionalProducer interface {
+		Producer
+		Begin() error
+		Commit(checkpoints map[string]Checkpoint) error
+		Abort() error
+	}
+
+	// TransactionalKafkaProducer send messages in kafka transactions which also write checkpoints of
+	// input files to a compacted topic, so read_committed consumers see every line exactly once
+	TransactionalKafkaProducer struct {
+		KafkaProducer
+		id              string
+		checkpointTopic string
+	}
+
+	// committedCheckpoint is a checkpoint record of checkpoint topic
+	committedCheckpoint struct {
+		ID         string     `json:"transactionalId"`
+		File       string     `json:"file"`
+		Checkpoint Checkpoint `json:"checkpoint"`
+	}
+)
+
+//TransactionLines get number of lines sent per transaction
+func (c *TransactionConfig) TransactionLines() int {
+	if c.Lines > 0 {
+		return c.Lines
+	}
+	return DefaultTransactionLines
+}
+
+//NewTransactionalKafkaProducer create producer committing checkpoints to given topic, sarama producer must be transactional
+func NewTransactionalKafkaProducer(producer sarama.SyncProducer, conf TransactionConfig) *TransactionalKafkaProducer {
+	return &TransactionalKafkaProducer{
+		KafkaProducer:   KafkaProducer{Prod: producer},
+		id:              conf.ID,
+		checkpointTopic: conf.CheckpointTopic,
+	}
+}
+
+//Send send message in current transaction, message sent outside a transaction is sent in a transaction of its own
+func (k *TransactionalKafkaProducer) Send(topic string, msg ProducerMessage) error {
+	if k.Prod.TxnStatus()&sarama.ProducerTxnFlagInTransaction != 0 {
+		return k.KafkaProducer.Send(topic, msg)
+	}
+	if err := k.Begin(); err != nil {
+		return err
+	}
+	if err := k.KafkaProducer.Send(topic, msg); err != nil {
+		k.Abort()
+		return err
+	}
+	return k.Prod.CommitTxn()
+}
+
+//Begin begin transaction
+func (k *TransactionalKafkaProducer) Begin() error {
+	return k.Prod.BeginTxn()
+}
+
+//Commit write checkpoints of input files and commit transaction, caller must abort it on error
+func (k *TransactionalKafkaProducer) Commit(checkpoints map[string]Checkpoint) error {
+	names := make([]string, 0, len(checkpoints))
+	for name := range checkpoints {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		value, err := json.Marshal(committedCheckpoint{ID: k.id, File: name, Checkpoint: checkpoints[name]})
+		if err != nil {
+			return err
+		}
+		_, _, err = k.Prod.SendMessage(&sarama.ProducerMessage{
+			Topic:     k.checkpointTopic,
+			Key:       sarama.StringEncoder(checkpointKey(k.id, name)),
+			Partition: -1,
+			Value:     sarama.ByteEncoder(value),
+			Metadata:  checkpointRecord{},
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return k.Prod.CommitTxn()
+}
+
+//Abort abort transaction, its messages are never seen by read_committed consumers
+func (k *TransactionalKafkaProducer) Abort() error {
+	return k.Prod.AbortTxn()
+}
+
+//checkpointKey get key of checkpoint record of an input file
+func checkpointKey(id string, name string) string {
+	return id + ":" + name
+}
+
+//LoadCheckpoints read checkpoints committed by transactional producer of given configuration, the
+//latest checkpoint of each input file is kept
+func LoadCheckpoints(brokers []string, conf ProducerConfig) (map[string]Checkpoint, error) {
+	if conf.Transaction == nil {
+		return nil, fmt.Errorf("producer.transaction is not set")
+	}
+	config, err := conf.SaramaConfig()
+	if err != nil {
+		return nil, err
+	}
+	client, err := sarama.NewClient(brokers, config)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+	return loadCheckpoints(client, conf.Transaction.ID, conf.Transaction.CheckpointTopic)
+}
+
+func loadCheckpoints(client sarama.Client, id string, topic string) (map[string]Checkpoint, error) {
+	partitions, err := client.Partitions(topic)
+	if err != nil {
+		return nil, err
+	}
+	checkpoints := make(map[string]Checkpoint)
+	for _, partition := range partitions {
+		if err := loadPartitionCheckpoints(client, id, topic, partition, checkpoints); err != nil {
+			return nil, err
+		}
+	}
+	return checkpoints, nil
+}
+
+//loadPartitionCheckpoints read committed records of a partition of checkpoint topic up to its last stable
+//offset. Records are fetched directly as transaction markers are never delivered by a consumer, the position
+//move past them so reading end exactly at the last stable offset, which count the markers
+func loadPartitionCheckpoints(client sarama.Client, id string, topic string, partition int32,
+	checkpoints map[string]Checkpoint) error {
+	oldest, err := client.GetOffset(topic, partition, sarama.OffsetOldest)
+	if err != nil {
+		return err
+	}
+	end, err := lastStableOffset(client, topic, partition)
+	if err != nil {
+		return err
+	}
+
+	config := client.Config()
+	fetchSize := config.Consumer.Fetch.Default
+	deadline := time.Now().Add(checkpointLoadTimeout)
+	for offset := oldest; offset < end; {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("read checkpoints of %s/%d timed out at offset %d of %d", topic, partition, offset, end)
+		}
+		block, err := fetchBlock(client, topic, partition, offset, fetchSize)
+		if err == sarama.ErrNotLeaderForPartition || err == sarama.ErrLeaderNotAvailable {
+			// Leader moved, find the new one
+			time.Sleep(config.Metadata.Retry.Backoff)
+			if err := client.RefreshMetadata(topic); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		next := readCheckpointRecords(block, offset, end, func(key []byte, value []byte) {
+			var record committedCheckpoint
+			if value == nil {
+				// Tombstone of a removed checkpoint
+				if key := string(key); strings.HasPrefix(key, checkpointKey(id, "")) {
+					delete(checkpoints, strings.TrimPrefix(key, checkpointKey(id, "")))
+				}
+			} else if err := json.Unmarshal(value, &record); err == nil && record.ID == id {
+				checkpoints[record.File] = record.Checkpoint
+			}
+		})
+		if next <= offset {
+			// Record batch larger than fetch size is returned partial
+			if config.Consumer.Fetch.Max <= 0 || fetchSize < config.Consumer.Fetch.Max {
+				fetchSize *= 2
+			}
+			continue
+		}
+		offset = next
+	}
+	return nil
+}
+
+//fetchBlock fetch committed records of a partition from given offset
+func fetchBlock(client sarama.Client, topic string, partition int32, offset int64, size int32) (*sarama.FetchResponseBlock, error) {
+	broker, err := client.Leader(topic, partition)
+	if err != nil {
+		return nil, err
+	}
+	request := &sarama.FetchRequest{
+		Version:     4,
+		MaxWaitTime: int32(client.Config().Consumer.MaxWaitTime / time.Millisecond),
+		MinBytes:    1,
+		MaxBytes:    sarama.MaxResponseSize,
+		Isolation:   sarama.ReadCommitted,
+	}
+	request.AddBlock(topic, partition, offset, size, -1)
+	response, err := broker.Fetch(request)
+	if err != nil {
+		return nil, err
+	}
+	block := response.GetBlock(topic, partition)
+	if block == nil {
+		return nil, sarama.ErrIncompleteResponse
+	}
+	if block.Err != sarama.ErrNoError {
+		return nil, block.Err
+	}
+	return block, nil
+}
+
+//readCheckpointRecords give records of fetched block between offset and end to read, records of aborted
+//transactions and markers are passed over. Returned offset is the position after the block
+func readCheckpointRecords(block *sarama.FetchResponseBlock, offset int64, end int64, read func(key []byte, value []byte)) int64 {
+	aborted := append([]*sarama.AbortedTransaction(nil), block.AbortedTransactions...)
+	sort.Slice(aborted, func(i, j int) bool { return aborted[i].FirstOffset < aborted[j].FirstOffset })
+	abortedProducers := make(map[int64]bool)
+
+	next := offset
+	deliver := func(recordOffset int64, key []byte, value []byte) {
+		if recordOffset >= offset && recordOffset < end {
+			read(key, value)
+		}
+		if recordOffset+1 > next {
+			next = recordOffset + 1
+		}
+	}
+	for _, records := range block.RecordsSet {
+		if records.MsgSet != nil {
+			for _, outer := range records.MsgSet.Messages {
+				inner := outer.Messages()
+				for _, msg := range inner {
+					// Offsets of compressed messages are relative to the last one since v1
+					msgOffset := msg.Offset
+					if outer.Msg.Set != nil && outer.Msg.Version >= 1 {
+						msgOffset = outer.Offset - inner[len(inner)-1].Offset + msg.Offset
+					}
+					deliver(msgOffset, msg.Msg.Key, msg.Msg.Value)
+				}
+			}
+			continue
+		}
+		batch := records.RecordBatch
+		if batch == nil || batch.PartialTrailingRecord {
+			// Batch cut by fetch size is fetched again from its start
+			break
+		}
+		lastOffset := batch.LastOffset()
+		if n := len(batch.Records); n > 0 && batch.FirstOffset+batch.Records[n-1].OffsetDelta > lastOffset {
+			lastOffset = batch.FirstOffset + batch.Records[n-1].OffsetDelta
+		}
+		for len(aborted) > 0 && aborted[0].FirstOffset <= lastOffset {
+			abortedProducers[aborted[0].ProducerID] = true
+			aborted = aborted[1:]
+		}
+
+		switch {
+		case batch.Control:
+			// Abort marker end an aborted transaction
+			if len(batch.Records) > 0 && controlRecordType(batch.Records[0].Key) == sarama.ControlRecordAbort {
+				delete(abortedProducers, batch.ProducerID)
+			}
+		case batch.IsTransactional && abortedProducers[batch.ProducerID]:
+			// Records of aborted transaction
+		default:
+			for _, record := range batch.Records {
+				deliver(batch.FirstOffset+record.OffsetDelta, record.Key, record.Value)
+			}
+		}
+		if lastOffset+1 > next {
+			next = lastOffset + 1
+		}
+	}
+	return next
+}
+
+//controlRecordType read type of transaction marker from key of its control record
+func controlRecordType(key []byte) sarama.ControlRecordType {
+	if len(key) < 4 {
+		return sarama.ControlRecordUnknown
+	}
+	switch binary.BigEndian.Uint16(key[2:4]) {
+	case 0:
+		return sarama.ControlRecordAbort
+	case 1:
+		return sarama.ControlRecordCommit
+	}
+	return sarama.ControlRecordUnknown
+}
+
+//lastStableOffset get offset up to which transactions of a partition are all committed or aborted
+func lastStableOffset(client sarama.Client, topic string, partition int32) (int64, error) {
+	broker, err := client.Leader(topic, partition)
+	if err != nil {
+		return 0, err
+	}
+	request := &sarama.OffsetRequest{Version: 2, IsolationLevel: sarama.ReadCommitted}
+	request.AddBlock(topic, partition, sarama.OffsetNewest, 1)
+	response, err := broker.GetAvailableOffsets(request)
+	if err != nil {
+		return 0, err
+	}
+	block := response.GetBlock(topic, partition)
+	if block == nil {
+		return 0, sarama.ErrIncompleteResponse
+	}
+	if block.Err != sarama.ErrNoError {
+		return 0, block.Err
+	}
+	return block.Offset, nil
+}
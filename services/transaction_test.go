@@ -0,0 +1,246 @@
+package services_test
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/Shopify/sarama"
+	"github.com/Shopify/sarama/mocks"
+	"github.com/stretchr/testify/assert"
+	"kafka-repush/services"
+	"log"
+	"testing"
+	"time"
+)
+
+var transactionConfig = services.TransactionConfig{ID: "repush-1", CheckpointTopic: "repush-checkpoints"}
+
+func newMockTransactionalProducer(t *testing.T) (*mocks.SyncProducer, *services.LogHandler) {
+	config, err := services.ProducerConfig{Transaction: &transactionConfig}.SaramaConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+	mockKafka := mocks.NewSyncProducer(t, config)
+	return mockKafka, services.NewLogHandler(services.NewTransactionalKafkaProducer(mockKafka, transactionConfig))
+}
+
+func TestTransactionCommit(t *testing.T) {
+	mockKafka, service := newMockTransactionalProducer(t)
+	cp := services.Checkpoint{Offset: 42, Line: 1}
+
+	mockKafka.ExpectSendMessageAndSucceed()
+	mockKafka.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(func(msg *sarama.ProducerMessage) error {
+		assert.Equal(t, "repush-checkpoints", msg.Topic)
+		key, _ := msg.Key.Encode()
+		assert.Equal(t, "repush-1:/var/log/app.log", string(key))
+		value, _ := msg.Value.Encode()
+		var record struct {
+			ID         string              `json:"transactionalId"`
+			File       string              `json:"file"`
+			Checkpoint services.Checkpoint `json:"checkpoint"`
+		}
+		if err := json.Unmarshal(value, &record); err != nil {
+			return err
+		}
+		assert.Equal(t, "repush-1", record.ID)
+		assert.Equal(t, "/var/log/app.log", record.File)
+		assert.Equal(t, cp, record.Checkpoint)
+		return nil
+	})
+
+	assert.True(t, service.Transactional())
+	assert.Nil(t, service.BeginTransaction())
+	assert.Nil(t, service.PushLine([]byte(`{"topic":"test","message":"first"}`)))
+	assert.Nil(t, service.CommitTransaction(map[string]services.Checkpoint{"/var/log/app.log": cp}))
+	assert.Equal(t, sarama.ProducerTxnFlagReady, mockKafka.TxnStatus())
+	assert.Nil(t, service.Close())
+}
+
+func TestCheckpointPartition(t *testing.T) {
+	conf := services.ProducerConfig{Partitioner: "random", Transaction: &transactionConfig}
+	config, err := conf.SaramaConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+	// Checkpoint of an input file always land on the partition of its hashed key
+	checkPartition := func(sent *sarama.ProducerMessage) error {
+		msg := *sent
+		msg.Partition = -1
+		partitioner := config.Producer.Partitioner(msg.Topic)
+		expected, err := services.NewRepushPartitioner(msg.Topic).Partition(&sarama.ProducerMessage{Partition: -1, Key: msg.Key}, 10)
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 20; i++ {
+			partition, err := partitioner.Partition(&msg, 10)
+			assert.Nil(t, err)
+			assert.Equal(t, expected, partition)
+		}
+		assert.True(t, partitioner.(sarama.DynamicConsistencyPartitioner).MessageRequiresConsistency(&msg))
+		return nil
+	}
+
+	mockKafka := mocks.NewSyncProducer(t, config)
+	producer := services.NewTransactionalKafkaProducer(mockKafka, transactionConfig)
+	mockKafka.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(checkPartition)
+	mockKafka.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(checkPartition)
+	assert.Nil(t, producer.Begin())
+	assert.Nil(t, producer.Commit(map[string]services.Checkpoint{"/var/log/a.log": {Offset: 1}, "/var/log/b.log": {Offset: 2}}))
+
+	store := services.NewKafkaCheckpointStore(nil, mockKafka, "pod", "repush-checkpoints")
+	mockKafka.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(checkPartition)
+	assert.Nil(t, store.Save(map[string]services.Checkpoint{"/var/log/a.log": {Offset: 1}}))
+	mockKafka.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(checkPartition)
+	assert.Nil(t, store.Save(nil))
+	assert.Nil(t, mockKafka.Close())
+}
+
+func TestTransactionSendOutsideTransaction(t *testing.T) {
+	mockKafka, service := newMockTransactionalProducer(t)
+	mockKafka.ExpectSendMessageAndSucceed()
+	mockKafka.ExpectSendMessageAndFail(sarama.ErrOutOfBrokers)
+
+	// Sent in a transaction of its own
+	assert.Nil(t, service.PushLine([]byte(`{"topic":"test","message":"retried"}`)))
+	assert.Equal(t, sarama.ProducerTxnFlagReady, mockKafka.TxnStatus())
+
+	err := service.PushLine([]byte(`{"topic":"test","message":"failed"}`))
+	var pushErr *services.PushError
+	assert.True(t, errors.As(err, &pushErr))
+	assert.Equal(t, services.ErrorClassBroker, pushErr.Class)
+	assert.Equal(t, sarama.ProducerTxnFlagReady, mockKafka.TxnStatus())
+	assert.Nil(t, service.Close())
+}
+
+func TestNotTransactional(t *testing.T) {
+	service := services.NewLogHandler(nil)
+	assert.False(t, service.Transactional())
+	assert.Equal(t, services.ErrNotTransactional, service.BeginTransaction())
+	assert.Equal(t, services.ErrNotTransactional, service.CommitTransaction(nil))
+	assert.Equal(t, services.ErrNotTransactional, service.AbortTransaction())
+}
+
+func TestLoadCheckpoints(t *testing.T) {
+	broker := sarama.NewMockBroker(t, 1)
+	defer broker.Close()
+
+	record := func(id string, file string, offset int64) sarama.Encoder {
+		value, err := json.Marshal(map[string]interface{}{
+			"transactionalId": id,
+			"file":            file,
+			"checkpoint":      services.Checkpoint{Offset: offset},
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+		return sarama.ByteEncoder(value)
+	}
+	topic := transactionConfig.CheckpointTopic
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetLeader(topic, 0, broker.BrokerID()).
+			SetLeader(topic, 1, broker.BrokerID()),
+		"OffsetRequest": sarama.NewMockOffsetResponse(t).
+			SetOffset(topic, 0, sarama.OffsetOldest, 0).
+			SetOffset(topic, 0, sarama.OffsetNewest, 3).
+			SetOffset(topic, 1, sarama.OffsetOldest, 0).
+			SetOffset(topic, 1, sarama.OffsetNewest, 1),
+		"FetchRequest": sarama.NewMockFetchResponse(t, 10).
+			SetMessage(topic, 0, 0, record("repush-1", "/var/log/a.log", 10)).
+			SetMessage(topic, 0, 1, record("repush-2", "/var/log/a.log", 99)).
+			SetMessage(topic, 0, 2, record("repush-1", "/var/log/a.log", 20)).
+			SetMessage(topic, 1, 0, record("repush-1", "/var/log/b.log", 5)).
+			SetHighWaterMark(topic, 0, 3).
+			SetHighWaterMark(topic, 1, 1),
+	})
+
+	checkpoints, err := services.LoadCheckpoints([]string{broker.Addr()}, services.ProducerConfig{Transaction: &transactionConfig})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]services.Checkpoint{
+		"/var/log/a.log": {Offset: 20},
+		"/var/log/b.log": {Offset: 5},
+	}, checkpoints)
+}
+
+func TestLoadCheckpointsTransactionMarkers(t *testing.T) {
+	broker := sarama.NewMockBroker(t, 1)
+	defer broker.Close()
+
+	record := func(file string, offset int64) sarama.Encoder {
+		value, err := json.Marshal(map[string]interface{}{
+			"transactionalId": "repush-1",
+			"file":            file,
+			"checkpoint":      services.Checkpoint{Offset: offset},
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+		return sarama.ByteEncoder(value)
+	}
+	topic := transactionConfig.CheckpointTopic
+	fetch := &sarama.FetchResponse{Version: 4}
+	fetch.AddRecordBatch(topic, 0, nil, record("/var/log/a.log", 10), 0, 1, true)
+	fetch.AddControlRecord(topic, 0, 1, 1, sarama.ControlRecordCommit)
+	fetch.AddRecordBatch(topic, 0, nil, record("/var/log/a.log", 99), 2, 2, true)
+	fetch.AddControlRecord(topic, 0, 3, 2, sarama.ControlRecordAbort)
+	fetch.AddRecordBatch(topic, 0, nil, record("/var/log/b.log", 5), 4, 1, true)
+	fetch.AddControlRecord(topic, 0, 5, 1, sarama.ControlRecordCommit)
+	fetch.GetBlock(topic, 0).AbortedTransactions = []*sarama.AbortedTransaction{{ProducerID: 2, FirstOffset: 2}}
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetLeader(topic, 0, broker.BrokerID()),
+		"OffsetRequest": sarama.NewMockOffsetResponse(t).
+			SetOffset(topic, 0, sarama.OffsetOldest, 0).
+			SetOffset(topic, 0, sarama.OffsetNewest, 6),
+		"FetchRequest": sarama.NewMockWrapper(fetch),
+	})
+
+	// Partition ending with a commit marker is read up to its last stable offset without waiting
+	conf := services.ProducerConfig{Transaction: &transactionConfig}
+	start := time.Now()
+	checkpoints, err := services.LoadCheckpoints([]string{broker.Addr()}, conf)
+	assert.Nil(t, err)
+	assert.Less(t, time.Since(start), time.Second)
+	assert.Equal(t, map[string]services.Checkpoint{
+		"/var/log/a.log": {Offset: 10},
+		"/var/log/b.log": {Offset: 5},
+	}, checkpoints)
+}
+
+func TestTransactionConfigValidate(t *testing.T) {
+	testCases := []struct {
+		name   string
+		conf   services.ProducerConfig
+		expErr string
+	}{
+		{
+			name:   "Missing id",
+			conf:   services.ProducerConfig{Transaction: &services.TransactionConfig{CheckpointTopic: "checkpoints"}},
+			expErr: "producer.transaction.id must be set",
+		},
+		{
+			name:   "Missing checkpoint topic",
+			conf:   services.ProducerConfig{Transaction: &services.TransactionConfig{ID: "repush"}},
+			expErr: "producer.transaction.checkpointTopic must be set",
+		},
+		{
+			name:   "Async producer",
+			conf:   services.ProducerConfig{Async: true, Transaction: &transactionConfig},
+			expErr: "producer.transaction can not be used with producer.async",
+		},
+		{
+			name:   "Leader acks",
+			conf:   services.ProducerConfig{Acks: "1", Transaction: &transactionConfig},
+			expErr: `producer.transaction needs producer.acks "all", got "1"`,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.conf.Validate()
+			if assert.NotNil(t, err) {
+				assert.Equal(t, tc.expErr, err.Error())
+			}
+		})
+	}
+}
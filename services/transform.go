@@ -0,0 +1,418 @@
+package services
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// Types of built-in transforms
+const (
+	TransformMatch  = "match"
+	TransformDrop   = "drop"
+	TransformHeader = "header"
+	TransformKey    = "key"
+	TransformTopic  = "topic"
+)
+
+type (
+	// Transform is a step changing a line before it is sent, a line is skipped when keep is false.
+	// Failed lines are recorded as failures
+	Transform interface {
+		Apply(info *LogInfo) (keep bool, err error)
+	}
+
+	// TransformFunc is a function used as transform
+	TransformFunc func(info *LogInfo) (bool, error)
+
+	// TransformFactory build a transform from its configuration
+	TransformFactory func(options json.RawMessage) (Transform, error)
+
+	// TransformConfig is a step of transforms configuration, type select the factory building it
+	// and the whole object is given to the factory as options
+	TransformConfig struct {
+		Type    string
+		Options json.RawMessage
+	}
+
+	// matchTransform keep lines whose message field has one of values, or skip them when exclude is set
+	matchTransform struct {
+		Path    string   `json:"path"`
+		Values  []string `json:"values"`
+		Exclude bool     `json:"exclude"`
+	}
+
+	// dropTransform remove fields from message
+	dropTransform struct {
+		Paths []string `json:"paths"`
+	}
+
+	// headerTransform set a header, replacing the header of same key
+	headerTransform struct {
+		Key   string `json:"key"`
+		Value string `json:"value"`
+		value *template.Template
+	}
+
+	// keyTransform set message key from a message field
+	keyTransform struct {
+		Path string `json:"path"`
+	}
+
+	// topicTransform set topic from a template
+	topicTransform struct {
+		Template string `json:"template"`
+		topic    *template.Template
+	}
+
+	// transformData is given to templates of transforms
+	transformData struct {
+		info *LogInfo
+		now  time.Time
+	}
+)
+
+var (
+	transformsMu       sync.RWMutex
+	transformFactories = map[string]TransformFactory{
+		TransformMatch:  newMatchTransform,
+		TransformDrop:   newDropTransform,
+		TransformHeader: newHeaderTransform,
+		TransformKey:    newKeyTransform,
+		TransformTopic:  newTopicTransform,
+		TransformFilter: newFilterTransform,
+	}
+)
+
+//Apply call the function
+func (f TransformFunc) Apply(info *LogInfo) (bool, error) {
+	return f(info)
+}
+
+//RegisterTransform make a custom transform available to transforms configuration under given type
+func RegisterTransform(name string, factory TransformFactory) {
+	transformsMu.Lock()
+	defer transformsMu.Unlock()
+	transformFactories[name] = factory
+}
+
+//UnmarshalJSON read transform type and keep the object as options
+func (c *TransformConfig) UnmarshalJSON(data []byte) error {
+	var head struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(data, &head); err != nil {
+		return err
+	}
+	c.Type = head.Type
+	c.Options = append(json.RawMessage(nil), data...)
+	return nil
+}
+
+//MarshalJSON write transform options which hold its type
+func (c TransformConfig) MarshalJSON() ([]byte, error) {
+	if len(c.Options) == 0 {
+		return json.Marshal(map[string]string{"type": c.Type})
+	}
+	return c.Options, nil
+}
+
+//NewTransforms build transforms of configuration in order
+func NewTransforms(configs []TransformConfig) ([]Transform, error) {
+	transformsMu.RLock()
+	defer transformsMu.RUnlock()
+
+	var transforms []Transform
+	for i, conf := range configs {
+		factory, ok := transformFactories[conf.Type]
+		if !ok {
+			return nil, fmt.Errorf("transforms[%d]: unknown transform type %q", i, conf.Type)
+		}
+		options := conf.Options
+		if len(options) == 0 {
+			options = json.RawMessage("{}")
+		}
+		transform, err := factory(options)
+		if err != nil {
+			return nil, fmt.Errorf("transforms[%d]: %v", i, err)
+		}
+		transforms = append(transforms, transform)
+	}
+	return transforms, nil
+}
+
+//SetTransforms replace transforms applied to lines after they are parsed and before topic rules
+func (h *LogHandler) SetTransforms(configs []TransformConfig) error {
+	transforms, err := NewTransforms(configs)
+	if err != nil {
+		return err
+	}
+	h.transforms = transforms
+	return nil
+}
+
+//AddTransform append a transform applied after configured ones
+func (h *LogHandler) AddTransform(transform Transform) {
+	h.transforms = append(h.transforms, transform)
+}
+
+//transform apply transforms in order, stop at first one skipping the line
+func (h *LogHandler) transform(info *LogInfo) (bool, error) {
+	for _, t := range h.transforms {
+		keep, err := t.Apply(info)
+		if err != nil || !keep {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func newMatchTransform(options json.RawMessage) (Transform, error) {
+	t := &matchTransform{}
+	if err := json.Unmarshal(options, t); err != nil {
+		return nil, err
+	}
+	if t.Path == "" {
+		return nil, errors.New("match path must be set")
+	}
+	return t, nil
+}
+
+func (t *matchTransform) Apply(info *LogInfo) (bool, error) {
+	matched := false
+	if doc, err := messageDocument(info); err == nil {
+		if value, ok := lookupField(doc, t.Path); ok {
+			text := fieldString(value)
+			for _, v := range t.Values {
+				if v == text {
+					matched = true
+					break
+				}
+			}
+		}
+	}
+	return matched != t.Exclude, nil
+}
+
+func newDropTransform(options json.RawMessage) (Transform, error) {
+	t := &dropTransform{}
+	if err := json.Unmarshal(options, t); err != nil {
+		return nil, err
+	}
+	if len(t.Paths) == 0 {
+		return nil, errors.New("drop paths must be set")
+	}
+	return t, nil
+}
+
+//Apply remove fields from message, fields of message are written in sorted order
+func (t *dropTransform) Apply(info *LogInfo) (bool, error) {
+	doc, err := messageDocument(info)
+	if err != nil {
+		return false, err
+	}
+	for _, path := range t.Paths {
+		deleteField(doc, path)
+	}
+	value, err := json.Marshal(doc)
+	if err != nil {
+		return false, err
+	}
+	setMessageValue(info, value)
+	return true, nil
+}
+
+func newHeaderTransform(options json.RawMessage) (Transform, error) {
+	t := &headerTransform{}
+	if err := json.Unmarshal(options, t); err != nil {
+		return nil, err
+	}
+	if t.Key == "" {
+		return nil, errors.New("header key must be set")
+	}
+	value, err := template.New("header").Parse(t.Value)
+	if err != nil {
+		return nil, err
+	}
+	t.value = value
+	return t, nil
+}
+
+func (t *headerTransform) Apply(info *LogInfo) (bool, error) {
+	value, err := executeTemplate(t.value, info)
+	if err != nil {
+		return false, err
+	}
+	headers := make(Headers, 0, len(info.Headers)+1)
+	for _, header := range info.Headers {
+		if header.Key != t.Key {
+			headers = append(headers, header)
+		}
+	}
+	info.Headers = append(headers, Header{Key: t.Key, Value: value})
+	return true, nil
+}
+
+func newKeyTransform(options json.RawMessage) (Transform, error) {
+	t := &keyTransform{}
+	if err := json.Unmarshal(options, t); err != nil {
+		return nil, err
+	}
+	if t.Path == "" {
+		return nil, errors.New("key path must be set")
+	}
+	return t, nil
+}
+
+func (t *keyTransform) Apply(info *LogInfo) (bool, error) {
+	doc, err := messageDocument(info)
+	if err != nil {
+		return false, err
+	}
+	value, ok := lookupField(doc, t.Path)
+	if !ok {
+		return false, fmt.Errorf("message has no field %s", t.Path)
+	}
+	key := fieldString(value)
+	info.MessageKey = &key
+	return true, nil
+}
+
+func newTopicTransform(options json.RawMessage) (Transform, error) {
+	t := &topicTransform{}
+	if err := json.Unmarshal(options, t); err != nil {
+		return nil, err
+	}
+	if t.Template == "" {
+		return nil, errors.New("topic template must be set")
+	}
+	topic, err := template.New("topic").Parse(t.Template)
+	if err != nil {
+		return nil, err
+	}
+	t.topic = topic
+	return t, nil
+}
+
+func (t *topicTransform) Apply(info *LogInfo) (bool, error) {
+	topic, err := executeTemplate(t.topic, info)
+	if err != nil {
+		return false, err
+	}
+	if topic == "" {
+		return false, errors.New("topic template give empty topic")
+	}
+	info.Topic = topic
+	return true, nil
+}
+
+func executeTemplate(tmpl *template.Template, info *LogInfo) (string, error) {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, transformData{info: info, now: time.Now()}); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+//Topic get topic of the line
+func (d transformData) Topic() string {
+	return d.info.Topic
+}
+
+//Key get message key of the line
+func (d transformData) Key() string {
+	if d.info.MessageKey == nil {
+		return ""
+	}
+	return *d.info.MessageKey
+}
+
+//Now get current time in RFC 3339 format
+func (d transformData) Now() string {
+	return d.now.UTC().Format(time.RFC3339Nano)
+}
+
+//Field get a field of message by dot separated path
+func (d transformData) Field(path string) (string, error) {
+	doc, err := messageDocument(d.info)
+	if err != nil {
+		return "", err
+	}
+	value, ok := lookupField(doc, path)
+	if !ok {
+		return "", fmt.Errorf("message has no field %s", path)
+	}
+	return fieldString(value), nil
+}
+
+//messageDocument parse message as a JSON object, numbers are kept as written
+func messageDocument(info *LogInfo) (map[string]interface{}, error) {
+	value, err := info.Value()
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(value))
+	decoder.UseNumber()
+	var doc map[string]interface{}
+	if err := decoder.Decode(&doc); err != nil || doc == nil {
+		return nil, errors.New("message is not a JSON object")
+	}
+	return doc, nil
+}
+
+//setMessageValue replace message bytes, keeping its encoding
+func setMessageValue(info *LogInfo, value []byte) {
+	if info.Encoding == EncodingBase64 {
+		info.Message = base64.StdEncoding.EncodeToString(value)
+		return
+	}
+	info.Message = string(value)
+}
+
+//lookupField find field of JSON object by dot separated path
+func lookupField(doc map[string]interface{}, path string) (interface{}, bool) {
+	names := strings.Split(path, ".")
+	var value interface{} = doc
+	for _, name := range names {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if value, ok = object[name]; !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+//deleteField remove field of JSON object by dot separated path
+func deleteField(doc map[string]interface{}, path string) {
+	names := strings.Split(path, ".")
+	object := doc
+	for _, name := range names[:len(names)-1] {
+		child, ok := object[name].(map[string]interface{})
+		if !ok {
+			return
+		}
+		object = child
+	}
+	delete(object, names[len(names)-1])
+}
+
+//fieldString get text of JSON value, strings are unquoted and other values written as JSON
+func fieldString(value interface{}) string {
+	if s, ok := value.(string); ok {
+		return s
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(data)
+}
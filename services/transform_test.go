@@ -0,0 +1,177 @@
+package services_test
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"kafka-repush/services"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestTransforms(t *testing.T) {
+	testCases := []struct {
+		name       string
+		transforms string
+		line       string
+		expTopic   string
+		expMessage string
+		expKey     string
+		expHeaders services.Headers
+		expErr     string
+	}{
+		{
+			name:       "Match keep line",
+			transforms: `[{"type":"match","path":"order.status","values":["paid","shipped"]}]`,
+			line:       `{"topic":"orders","message":"{\"order\":{\"status\":\"paid\"}}"}`,
+			expTopic:   "orders",
+			expMessage: `{"order":{"status":"paid"}}`,
+		},
+		{
+			name:       "Match skip line",
+			transforms: `[{"type":"match","path":"order.status","values":["paid"]}]`,
+			line:       `{"topic":"orders","message":"{\"order\":{\"status\":\"new\"}}"}`,
+		},
+		{
+			name:       "Match exclude line",
+			transforms: `[{"type":"match","path":"test","values":["true"],"exclude":true}]`,
+			line:       `{"topic":"orders","message":"{\"test\":true}"}`,
+		},
+		{
+			name:       "Drop fields",
+			transforms: `[{"type":"drop","paths":["password","user.ssn","missing.field"]}]`,
+			line:       `{"topic":"users","message":"{\"user\":{\"ssn\":\"1\",\"name\":\"a\"},\"password\":\"x\",\"id\":12345678901234567890}"}`,
+			expTopic:   "users",
+			expMessage: `{"id":12345678901234567890,"user":{"name":"a"}}`,
+		},
+		{
+			name:       "Drop fields of base64 message",
+			transforms: `[{"type":"drop","paths":["password"]}]`,
+			line:       `{"topic":"users","message":"eyJpZCI6MSwicGFzc3dvcmQiOiJ4In0=","encoding":"base64"}`,
+			expTopic:   "users",
+			expMessage: "eyJpZCI6MX0=",
+		},
+		{
+			name:       "Set header, key and topic",
+			transforms: `[{"type":"header","key":"source","value":"{{.Topic}}"},{"type":"key","path":"id"},{"type":"topic","template":"{{.Topic}}-{{.Field \"region\"}}"}]`,
+			line:       `{"topic":"orders","message":"{\"id\":7,\"region\":\"eu\"}","headers":{"source":"old","trace":"t1"}}`,
+			expTopic:   "orders-eu",
+			expMessage: `{"id":7,"region":"eu"}`,
+			expKey:     "7",
+			expHeaders: services.Headers{{Key: "trace", Value: "t1"}, {Key: "source", Value: "orders"}},
+		},
+		{
+			name:       "Key field missing",
+			transforms: `[{"type":"key","path":"id"}]`,
+			line:       `{"topic":"orders","message":"{\"name\":\"a\"}"}`,
+			expErr:     "message has no field id",
+		},
+		{
+			name:       "Message is not JSON",
+			transforms: `[{"type":"drop","paths":["id"]}]`,
+			line:       `{"topic":"orders","message":"plain text"}`,
+			expErr:     "message is not a JSON object",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var configs []services.TransformConfig
+			if err := json.Unmarshal([]byte(tc.transforms), &configs); err != nil {
+				log.Fatal(err)
+			}
+			ctrl := gomock.NewController(t)
+			mockKafka := NewMockProducer(ctrl)
+			if tc.expTopic != "" {
+				mockKafka.EXPECT().Send(tc.expTopic, gomock.Any()).DoAndReturn(func(topic string, msg services.ProducerMessage) error {
+					info := msg.(services.LogInfo)
+					assert.Equal(t, tc.expMessage, info.Message)
+					if tc.expKey != "" && assert.NotNil(t, info.MessageKey) {
+						assert.Equal(t, tc.expKey, *info.MessageKey)
+					}
+					if tc.expHeaders != nil {
+						assert.Equal(t, tc.expHeaders, info.Headers)
+					}
+					return nil
+				})
+			}
+			service := services.NewLogHandler(mockKafka)
+			assert.Nil(t, service.SetTransforms(configs))
+
+			err := service.PushLine([]byte(tc.line))
+			if tc.expErr == "" {
+				assert.Nil(t, err)
+				return
+			}
+			var pushErr *services.PushError
+			if assert.True(t, errors.As(err, &pushErr)) {
+				assert.Equal(t, services.ErrorClassTransform, pushErr.Class)
+				assert.Equal(t, tc.expErr, pushErr.Error())
+			}
+		})
+	}
+}
+
+func TestCustomTransform(t *testing.T) {
+	services.RegisterTransform("upper", func(options json.RawMessage) (services.Transform, error) {
+		return services.TransformFunc(func(info *services.LogInfo) (bool, error) {
+			info.Message = strings.ToUpper(info.Message)
+			return true, nil
+		}), nil
+	})
+	var configs []services.TransformConfig
+	if err := json.Unmarshal([]byte(`[{"type":"upper"}]`), &configs); err != nil {
+		log.Fatal(err)
+	}
+
+	ctrl := gomock.NewController(t)
+	mockKafka := NewMockProducer(ctrl)
+	mockKafka.EXPECT().Send("orders", gomock.Any()).DoAndReturn(func(topic string, msg services.ProducerMessage) error {
+		assert.Equal(t, "HELLO!", msg.(services.LogInfo).Message)
+		return nil
+	})
+	service := services.NewLogHandler(mockKafka)
+	assert.Nil(t, service.SetTransforms(configs))
+	service.AddTransform(services.TransformFunc(func(info *services.LogInfo) (bool, error) {
+		info.Message += "!"
+		return true, nil
+	}))
+	assert.Nil(t, service.PushLine([]byte(`{"topic":"orders","message":"hello"}`)))
+}
+
+func TestNewTransformsInvalid(t *testing.T) {
+	testCases := []struct {
+		name       string
+		transforms string
+		expErr     string
+	}{
+		{
+			name:       "Unknown type",
+			transforms: `[{"type":"match","path":"a"},{"type":"lowercase"}]`,
+			expErr:     `transforms[1]: unknown transform type "lowercase"`,
+		},
+		{
+			name:       "Missing path",
+			transforms: `[{"type":"key"}]`,
+			expErr:     "transforms[0]: key path must be set",
+		},
+		{
+			name:       "Invalid template",
+			transforms: `[{"type":"topic","template":"{{.Topic"}]`,
+			expErr:     `transforms[0]: template: topic:1: unclosed action`,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var configs []services.TransformConfig
+			if err := json.Unmarshal([]byte(tc.transforms), &configs); err != nil {
+				log.Fatal(err)
+			}
+			_, err := services.NewTransforms(configs)
+			if assert.NotNil(t, err) {
+				assert.Equal(t, tc.expErr, err.Error())
+			}
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package services
+
+import (
+	"log"
+	"time"
+)
+
+// watcher wake up follower whenever the input file may have changed,
+// file system notifications are used when available and the file is polled in any case
+type watcher struct {
+	events chan struct{}
+	done   chan struct{}
+	notify func() error
+}
+
+//newWatcher watch directory of input file and poll it every interval
+func newWatcher(name string, interval time.Duration) *watcher {
+	w := &watcher{
+		events: make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+	closeNotify, err := w.watch(name)
+	if err != nil {
+		log.Printf("File notification unavailable, polling %s every %v, err: %v \n", name, interval, err)
+	} else {
+		w.notify = closeNotify
+	}
+	go w.poll(interval)
+	return w
+}
+
+//wake signal a change without blocking, pending signals are merged
+func (w *watcher) wake() {
+	select {
+	case w.events <- struct{}{}:
+	default:
+	}
+}
+
+//poll signal a change every interval
+func (w *watcher) poll(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			w.wake()
+		case <-w.done:
+			return
+		}
+	}
+}
+
+//close stop polling and file system notifications
+func (w *watcher) close() error {
+	close(w.done)
+	if w.notify != nil {
+		return w.notify()
+	}
+	return nil
+}
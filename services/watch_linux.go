@@ -0,0 +1,37 @@
+//go:build linux
+// +build linux
+
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+//watch subscribe to inotify events of the directory holding input file, so rotation is seen as well
+func (w *watcher) watch(name string) (func() error, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, err
+	}
+	mask := uint32(syscall.IN_MODIFY | syscall.IN_CREATE | syscall.IN_DELETE |
+		syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO | syscall.IN_CLOSE_WRITE)
+	if _, err := syscall.InotifyAddWatch(fd, filepath.Dir(name), mask); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+
+	// Non blocking descriptor is handled by the runtime poller, so Close unblock Read
+	file := os.NewFile(uintptr(fd), "inotify")
+	go func() {
+		buf := make([]byte, 4096)
+		for {
+			if _, err := file.Read(buf); err != nil {
+				return
+			}
+			w.wake()
+		}
+	}()
+	return file.Close, nil
+}
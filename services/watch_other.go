@@ -0,0 +1,11 @@
+//go:build !linux
+// +build !linux
+
+package services
+
+import "errors"
+
+//watch is not supported on this platform, input file is polled only
+func (w *watcher) watch(name string) (func() error, error) {
+	return nil, errors.New("inotify is not supported")
+}
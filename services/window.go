@@ -0,0 +1,229 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// DefaultTimeField is the field of a line holding its timestamp
+const DefaultTimeField = "timestamp"
+
+// TimeWindow select lines whose timestamp is at or after From and before To, zero bound is open.
+// Field is a dot separated path of line, or of JSON message body when it start with "$.",
+// holding a RFC 3339 timestamp or milliseconds since epoch. Lines are read with Decoder, the
+// default field is the timestamp it decode and lines written as LogInfo are read without decoder
+type TimeWindow struct {
+	From    time.Time
+	To      time.Time
+	Field   string
+	Decoder Decoder
+}
+
+//IsZero report whether window has no bound
+func (w TimeWindow) IsZero() bool {
+	return w.From.IsZero() && w.To.IsZero()
+}
+
+//Contains report whether time is inside window
+func (w TimeWindow) Contains(t time.Time) bool {
+	return !t.Before(w.From) && (w.To.IsZero() || t.Before(w.To))
+}
+
+//Past report whether time is after window, nothing after it is inside window when lines are sorted
+func (w TimeWindow) Past(t time.Time) bool {
+	return !w.To.IsZero() && !t.Before(w.To)
+}
+
+//LineTime get timestamp of a line, false when it has none
+func (w TimeWindow) LineTime(line []byte) (time.Time, bool) {
+	field := w.Field
+	if field == "" {
+		field = DefaultTimeField
+	}
+
+	var doc map[string]interface{}
+	if field == DefaultTimeField && w.Decoder != nil {
+		// Timestamp decoded from the line, a JSON line may still have it as a field
+		if info, err := w.Decoder.Decode(line); err == nil && !info.Timestamp.IsZero() {
+			return info.Timestamp, true
+		}
+	}
+	if strings.HasPrefix(field, "$.") {
+		info, err := decodeLine(w.Decoder, line)
+		if err != nil {
+			return time.Time{}, false
+		}
+		body, err := messageDocument(&info)
+		if err != nil {
+			return time.Time{}, false
+		}
+		doc, field = body, strings.TrimPrefix(field, "$.")
+	} else {
+		decoder := json.NewDecoder(bytes.NewReader(line))
+		decoder.UseNumber()
+		if err := decoder.Decode(&doc); err != nil {
+			return time.Time{}, false
+		}
+	}
+	value, ok := lookupField(doc, field)
+	if !ok {
+		return time.Time{}, false
+	}
+	switch v := value.(type) {
+	case string:
+		t, err := time.Parse(time.RFC3339Nano, v)
+		return t, err == nil
+	case json.Number:
+		ms, err := v.Int64()
+		return time.Unix(0, ms*int64(time.Millisecond)), err == nil
+	}
+	return time.Time{}, false
+}
+
+//SeekTime find offset of the first line at or after start of window by binary search, lines of
+//input file must be sorted by time. Lines without timestamp are passed over, so returned offset may be
+//before the first line of window but never after. Compressed file is read from the beginning
+func SeekTime(name string, w TimeWindow) (int64, error) {
+	s, err := openSegment(name)
+	if err != nil {
+		return 0, err
+	}
+	defer s.close()
+	if s.compressed() || w.From.IsZero() {
+		return 0, nil
+	}
+	info, err := s.file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	size := info.Size()
+
+	// Every line starting before lo is before window, hi only narrow the search
+	lo, hi := int64(0), size
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		start, err := lineStart(s.file, mid, size)
+		if err != nil {
+			return 0, err
+		}
+		t, end, ok, err := timedLine(s.file, start, hi, size, w)
+		if err != nil {
+			return 0, err
+		}
+		if ok && t.Before(w.From) {
+			lo = end
+		} else {
+			hi = mid
+		}
+	}
+	return lineStart(s.file, lo, size)
+}
+
+//lineStart get offset of the first line starting at or after pos
+func lineStart(file io.ReaderAt, pos int64, size int64) (int64, error) {
+	if pos == 0 {
+		return 0, nil
+	}
+	// Line start at pos when the byte before it end a line
+	reader := bufio.NewReader(io.NewSectionReader(file, pos-1, size-pos+1))
+	skipped, err := reader.ReadBytes('\n')
+	if err == io.EOF {
+		return size, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return pos - 1 + int64(len(skipped)), nil
+}
+
+//timedLine find the first line with a timestamp starting between start and limit, end is the
+//offset right after it
+func timedLine(file io.ReaderAt, start int64, limit int64, size int64, w TimeWindow) (time.Time, int64, bool, error) {
+	reader := bufio.NewReader(io.NewSectionReader(file, start, size-start))
+	end := start
+	for end < limit {
+		line, err := reader.ReadBytes('\n')
+		if len(line) == 0 && err == io.EOF {
+			break
+		}
+		if err != nil && err != io.EOF {
+			return time.Time{}, 0, false, err
+		}
+		end += int64(len(line))
+		if t, ok := w.LineTime(bytes.TrimRight(line, "\r\n")); ok {
+			return t, end, true, nil
+		}
+	}
+	return time.Time{}, 0, false, nil
+}
+
+//OpenLogReaderAt open input file at given offset, which must be the start of a line. Checkpoint
+//is not looked at and line numbers of positions count from offset
+func OpenLogReaderAt(name string, offset int64) (*LogReader, error) {
+	s, err := openSegment(name)
+	if err != nil {
+		return nil, err
+	}
+	r := &LogReader{name: name}
+	if err := r.start(s, Checkpoint{Offset: offset}); err != nil {
+		r.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+//OpenWindowReader open rotated segments of input file from oldest to newest and then input file for reading
+//time window. Sorted input is read from the first line of window, found by binary search in uncompressed
+//segments, segments wholly before window are passed over and compressed ones are scanned from their beginning
+func OpenWindowReader(name string, w TimeWindow, sorted bool) (*LogReader, error) {
+	segments, err := rotatedSegments(name)
+	if err != nil {
+		return nil, err
+	}
+	paths := make([]string, 0, len(segments)+1)
+	for _, s := range segments {
+		paths = append(paths, s.path)
+	}
+	paths = append(paths, name)
+
+	first, offset := 0, int64(0)
+	for sorted && first < len(paths) {
+		if offset, err = SeekTime(paths[first], w); err != nil {
+			return nil, err
+		}
+		if first == len(paths)-1 {
+			break
+		}
+		info, err := os.Stat(paths[first])
+		if err != nil {
+			return nil, err
+		}
+		if offset < info.Size() {
+			break
+		}
+		first++
+	}
+
+	s, err := openSegment(paths[first])
+	if err != nil {
+		return nil, err
+	}
+	r := &LogReader{name: name}
+	if first < len(paths)-1 {
+		r.pending = paths[first+1 : len(paths)-1]
+	}
+	cp := Checkpoint{Offset: offset}
+	if paths[first] != name {
+		cp.Segment = paths[first]
+	}
+	if err := r.start(s, cp); err != nil {
+		r.Close()
+		return nil, err
+	}
+	return r, nil
+}
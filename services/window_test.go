@@ -0,0 +1,271 @@
+package services_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"kafka-repush/services"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTimeWindowLineTime(t *testing.T) {
+	expTime := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+	testCases := []struct {
+		name  string
+		field string
+		line  string
+		expOk bool
+	}{
+		{
+			name:  "Default field",
+			line:  `{"topic":"orders","message":"a","timestamp":"2021-03-01T10:00:00Z"}`,
+			expOk: true,
+		},
+		{
+			name:  "Milliseconds since epoch",
+			field: "meta.time",
+			line:  `{"topic":"orders","message":"a","meta":{"time":1614592800000}}`,
+			expOk: true,
+		},
+		{
+			name:  "Message field",
+			field: "$.createdAt",
+			line:  `{"topic":"orders","message":"{\"createdAt\":\"2021-03-01T11:00:00+01:00\"}"}`,
+			expOk: true,
+		},
+		{
+			name: "Missing field",
+			line: `{"topic":"orders","message":"a"}`,
+		},
+		{
+			name: "Invalid time",
+			line: `{"topic":"orders","message":"a","timestamp":"yesterday"}`,
+		},
+		{
+			name: "Invalid line",
+			line: `{"topic":"orders"`,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			lineTime, ok := services.TimeWindow{Field: tc.field}.LineTime([]byte(tc.line))
+			assert.Equal(t, tc.expOk, ok)
+			if tc.expOk {
+				assert.True(t, expTime.Equal(lineTime))
+			}
+		})
+	}
+}
+
+func TestTimeWindowDecodedLineTime(t *testing.T) {
+	expTime := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+	testCases := []struct {
+		name  string
+		input string
+		field string
+		line  string
+		expOk bool
+	}{
+		{
+			name:  "CSV timestamp column",
+			input: `{"format":"csv","topic":"orders","columns":["timestamp","value"]}`,
+			line:  `2021-03-01T10:00:00Z,"{""id"":1}"`,
+			expOk: true,
+		},
+		{
+			name:  "Logfmt timestamp field",
+			input: `{"format":"logfmt","topic":"orders","timestampField":"ts"}`,
+			line:  `ts=1614592800000 level=info msg="paid"`,
+			expOk: true,
+		},
+		{
+			name:  "Message field of raw line",
+			input: `{"format":"raw","topic":"orders"}`,
+			field: "$.createdAt",
+			line:  `{"createdAt":"2021-03-01T10:00:00Z"}`,
+			expOk: true,
+		},
+		{
+			name:  "JSON line field not mapped",
+			input: `{"format":"json","topicField":"kind"}`,
+			line:  `{"kind":"orders","timestamp":"2021-03-01T10:00:00Z"}`,
+			expOk: true,
+		},
+		{
+			name:  "Logfmt line without timestamp",
+			input: `{"format":"logfmt","topic":"orders","timestampField":"ts"}`,
+			line:  `level=info msg="paid"`,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			input := &services.InputConfig{}
+			if err := json.Unmarshal([]byte(tc.input), input); err != nil {
+				log.Fatal(err)
+			}
+			decoder, err := services.NewDecoder(input)
+			if err != nil {
+				log.Fatal(err)
+			}
+			lineTime, ok := services.TimeWindow{Field: tc.field, Decoder: decoder}.LineTime([]byte(tc.line))
+			assert.Equal(t, tc.expOk, ok)
+			if tc.expOk {
+				assert.True(t, expTime.Equal(lineTime))
+			}
+		})
+	}
+}
+
+func TestSeekTime(t *testing.T) {
+	dir, err := ioutil.TempDir("", "window")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// A line per minute, every tenth line has no timestamp
+	start := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+	var lines []string
+	for i := 0; i < 500; i++ {
+		if i%10 == 5 {
+			lines = append(lines, `{"topic":"orders","message":"untimed"}`)
+			continue
+		}
+		lines = append(lines, fmt.Sprintf(`{"topic":"orders","message":"%d","timestamp":"%s"}`,
+			i, start.Add(time.Duration(i)*time.Minute).Format(time.RFC3339)))
+	}
+	name := filepath.Join(dir, "log.txt")
+	if err := ioutil.WriteFile(name, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		log.Fatal(err)
+	}
+
+	testCases := []struct {
+		name    string
+		from    time.Time
+		expLine int
+	}{
+		{name: "Open start", expLine: 0},
+		{name: "Before first line", from: start.Add(-time.Hour), expLine: 0},
+		{name: "Exact line", from: start.Add(123 * time.Minute), expLine: 123},
+		{name: "Between lines", from: start.Add(123*time.Minute + time.Second), expLine: 124},
+		{name: "After untimed line", from: start.Add(236 * time.Minute), expLine: 236},
+		{name: "After last line", from: start.Add(24 * time.Hour), expLine: 500},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			offset, err := services.SeekTime(name, services.TimeWindow{From: tc.from})
+			assert.Nil(t, err)
+
+			// Seek may stop at untimed lines right before first line of window
+			var expOffsets []int64
+			expOffset := int64(0)
+			for i := 0; i < tc.expLine; i++ {
+				expOffset += int64(len(lines[i]) + 1)
+			}
+			expOffsets = append(expOffsets, expOffset)
+			if tc.expLine > 0 && tc.expLine%10 == 6 {
+				expOffsets = append(expOffsets, expOffset-int64(len(lines[tc.expLine-1])+1))
+			}
+			assert.Contains(t, expOffsets, offset)
+
+			reader, err := services.OpenLogReaderAt(name, offset)
+			if err != nil {
+				log.Fatal(err)
+			}
+			defer reader.Close()
+			line, err := reader.ReadLine()
+			if tc.expLine < len(lines) && offset == expOffsets[0] {
+				assert.Nil(t, err)
+				assert.Equal(t, lines[tc.expLine], string(line))
+			}
+		})
+	}
+}
+
+func TestOpenWindowReader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "window")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Ten lines a minute apart in each of log.txt.2, compressed log.txt.1.gz and log.txt, in each of
+	// plain.txt.1 and plain.txt, and in single.txt which has no rotated segment
+	start := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+	line := func(i int) string {
+		return fmt.Sprintf(`{"topic":"orders","message":"%d","timestamp":"%s"}`, i, start.Add(time.Duration(i)*time.Minute).Format(time.RFC3339))
+	}
+	name := filepath.Join(dir, "log.txt")
+	for i, path := range []string{name + ".2", name + ".1", name} {
+		for j := 0; j < 10; j++ {
+			appendFile(path, line(i*10+j)+"\n")
+		}
+	}
+	gzipFile(name + ".1")
+	setModTime(name+".2", 2*time.Hour)
+	setModTime(name+".1.gz", time.Hour)
+	plain := filepath.Join(dir, "plain.txt")
+	for i, path := range []string{plain + ".1", plain} {
+		for j := 0; j < 10; j++ {
+			appendFile(path, line(i*10+j)+"\n")
+		}
+	}
+	setModTime(plain+".1", time.Hour)
+	single := filepath.Join(dir, "single.txt")
+	for i := 0; i < 10; i++ {
+		appendFile(single, line(i)+"\n")
+	}
+
+	testCases := []struct {
+		name     string
+		input    string
+		from     int
+		to       int
+		sorted   bool
+		expFirst string
+	}{
+		{name: "Unsorted input scan every segment", input: name, from: 15, to: 25, expFirst: line(0)},
+		{name: "Sorted input pass over segments before window", input: name, from: 15, to: 25, sorted: true, expFirst: line(10)},
+		{name: "Sorted input with window in input file", input: plain, from: 15, to: 18, sorted: true, expFirst: line(15)},
+		{name: "Unsorted input without rotated segment", input: single, from: 3, to: 6, expFirst: line(0)},
+		{name: "Sorted input without rotated segment", input: single, from: 3, to: 6, sorted: true, expFirst: line(3)},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			w := services.TimeWindow{From: start.Add(time.Duration(tc.from) * time.Minute), To: start.Add(time.Duration(tc.to) * time.Minute)}
+			var expLines []string
+			for i := tc.from; i < tc.to; i++ {
+				expLines = append(expLines, line(i))
+			}
+
+			reader, err := services.OpenWindowReader(tc.input, w, tc.sorted)
+			if err != nil {
+				log.Fatal(err)
+			}
+			defer reader.Close()
+
+			var first string
+			var lines []string
+			for {
+				line, err := reader.ReadLine()
+				if err != nil {
+					break
+				}
+				if first == "" {
+					first = string(line)
+				}
+				if lineTime, ok := w.LineTime(line); ok && w.Contains(lineTime) {
+					lines = append(lines, string(line))
+				}
+			}
+			assert.Equal(t, tc.expFirst, first)
+			assert.Equal(t, expLines, lines)
+		})
+	}
+}